@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/21b030939/golang-project/pkg/schedule/model"
+	"github.com/21b030939/golang-project/pkg/schedule/validator"
+)
+
+// createAdminCommand bootstraps an administrator, since the permission API can only be used by
+// someone who already has permissions:admin. It's run as
+//
+//	schedule [flags] create-admin -email=admin@example.com -name=Admin
+//
+// If no user with the email exists, an activated user is created with the password read from the
+// ADMIN_PASSWORD environment variable or, if that's empty, from the first line of standard input.
+// The user, new or existing, is then assigned to the admin role, which grants every permission
+// code.
+func (app *application) createAdminCommand(args []string) error {
+	fs := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	email := fs.String("email", "", "Email of the administrator")
+	name := fs.String("name", "Administrator", "Name of the administrator, if the user is created")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	v := validator.New()
+
+	if model.ValidateEmail(v, *email); !v.Valid() {
+		return validationError(v)
+	}
+
+	user, err := app.models.Users.GetByEmail(ctx, *email)
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		user, err = app.createAdminUser(ctx, *name, *email)
+		if err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	}
+
+	err = app.models.Roles.AddForUser(ctx, user.ID, model.RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("assigned administrator role", map[string]string{
+		"email": user.Email,
+		"role":  model.RoleAdmin,
+	})
+
+	return nil
+}
+
+// createAdminUser inserts an activated user for createAdminCommand.
+func (app *application) createAdminUser(ctx context.Context, name, email string) (*model.User, error) {
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return nil, fmt.Errorf("reading password: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	user := &model.User{
+		Name:      name,
+		Email:     email,
+		Activated: true,
+	}
+
+	err := user.Password.Set(password)
+	if err != nil {
+		return nil, err
+	}
+
+	v := validator.New()
+
+	if model.ValidateUser(v, user); !v.Valid() {
+		return nil, validationError(v)
+	}
+
+	err = app.models.Users.Insert(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	app.logger.PrintInfo("created administrator", map[string]string{"email": user.Email})
+
+	return user, nil
+}
+
+// validationError formats the errors of a validator for the command line.
+func validationError(v *validator.Validator) error {
+	messages := make([]string, 0, len(v.Errors))
+	for key, message := range v.Errors {
+		messages = append(messages, key+" "+message)
+	}
+
+	sort.Strings(messages)
+
+	return errors.New(strings.Join(messages, ", "))
+}
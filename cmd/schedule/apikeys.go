@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/21b030939/golang-project/pkg/schedule/model"
+	"github.com/21b030939/golang-project/pkg/schedule/validator"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// apiKeyTouchInterval is how often the last use of an API key is recorded. Keys are often
+	// used for bursts of requests, which don't each need a write to the database.
+	apiKeyTouchInterval = time.Minute
+	// maxAllowedIPs is the maximum number of addresses or networks an API key can be
+	// restricted to.
+	maxAllowedIPs = 20
+)
+
+// authenticateAPIKey authenticates a request made with an "Authorization: ApiKey <key>" header.
+// The request is made as the owner of the key, with the permission codes of the key that the
+// owner still has. If the key is invalid, the error response is sent and false is returned.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, plaintext string) (*http.Request, bool) {
+	v := validator.New()
+
+	if model.ValidateAPIKeyPlaintext(v, plaintext); !v.Valid() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return nil, false
+	}
+
+	key, err := app.models.APIKeys.GetForKey(r.Context(), plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if !apiKeyAllowsIP(key, remoteIP(r)) {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(r.Context(), key.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			// The owner was deleted since the key was looked up.
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	// Permissions revoked from the owner are revoked from their keys too.
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if key.LastUsedAt == nil || time.Since(*key.LastUsedAt) > apiKeyTouchInterval {
+		// Failing to record the use of the key isn't worth failing the request for.
+		err = app.models.APIKeys.Touch(r.Context(), key.ID)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"api_key_id": strconv.FormatInt(key.ID, 10)})
+		}
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetPermissions(r, key.Permissions.Intersect(permissions))
+	r = app.contextSetAPIKey(r, key)
+
+	return r, true
+}
+
+// apiKeyAllowsIP reports whether the key may be used from the client address ip.
+func apiKeyAllowsIP(key *model.APIKey, ip string) bool {
+	if len(key.AllowedIPs) == 0 {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, allowed := range key.AllowedIPs {
+		prefix, err := netip.ParsePrefix(allowed)
+		if err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// createAPIKeyHandler creates an API key for the current user.
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	app.createAPIKey(w, r, app.contextGetUser(r))
+}
+
+// createUserAPIKeyHandler lets an administrator create an API key for another user, typically
+// the account of a service integrating with the API.
+func (app *application) createUserAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	owner, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	app.createAPIKey(w, r, owner)
+}
+
+// createAPIKey creates an API key owned by owner, with a subset of the owner's permission codes.
+// The key is only returned in plaintext in this response. API keys can't be used to create other
+// keys, so that a leaked key can't be used to keep access once it's revoked: the routes are
+// wrapped with requireUserSession.
+func (app *application) createAPIKey(w http.ResponseWriter, r *http.Request, owner *model.User) {
+	var input struct {
+		Name        string     `json:"name"`
+		Permissions []string   `json:"permissions"`
+		AllowedIPs  []string   `json:"allowed_ips"`
+		Expiry      *time.Time `json:"expiry"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ownerPermissions, err := app.models.Permissions.GetAllForUser(r.Context(), owner.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	key := &model.APIKey{
+		UserID:      owner.ID,
+		CreatedBy:   app.contextGetUser(r).ID,
+		Name:        input.Name,
+		Permissions: input.Permissions,
+		Expiry:      input.Expiry,
+	}
+
+	v := validator.New()
+
+	v.Check(input.Name != "", "name", "must be provided")
+	v.Check(len(input.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(input.Permissions) > 0, "permissions", "must contain at least one permission code")
+	v.Check(validator.Unique(input.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range input.Permissions {
+		if !ownerPermissions.Include(code) {
+			v.AddError("permissions", "must only contain permission codes the owner of the key has")
+			break
+		}
+	}
+
+	v.Check(len(input.AllowedIPs) <= maxAllowedIPs, "allowed_ips", "must not contain more than 20 entries")
+	for _, allowed := range input.AllowedIPs {
+		prefix, ok := parseAllowedIP(allowed)
+		if !ok {
+			v.AddError("allowed_ips", "must only contain IP addresses or networks in CIDR notation")
+			break
+		}
+		key.AllowedIPs = append(key.AllowedIPs, prefix)
+	}
+
+	if input.Expiry != nil {
+		v.Check(input.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.APIKeys.Insert(r.Context(), key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.PrintInfo("api key created", map[string]string{
+		"api_key_id": strconv.FormatInt(key.ID, 10),
+		"user_id":    strconv.FormatInt(key.UserID, 10),
+		"created_by": strconv.FormatInt(key.CreatedBy, 10),
+	})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// parseAllowedIP parses an IP address or a network in CIDR notation, and returns it as a
+// network: a single address becomes a /32 or /128 network.
+func parseAllowedIP(s string) (string, bool) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()).String(), true
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return "", false
+	}
+
+	return prefix.Masked().String(), true
+}
+
+// listAPIKeysHandler lists the API keys of the current user.
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeAPIKeys(w, r, app.contextGetUser(r))
+}
+
+// listUserAPIKeysHandler lists the API keys of a user.
+func (app *application) listUserAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	owner, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeAPIKeys(w, r, owner)
+}
+
+// writeAPIKeys responds with every API key of owner, without the keys themselves.
+func (app *application) writeAPIKeys(w http.ResponseWriter, r *http.Request, owner *model.User) {
+	keys, err := app.models.APIKeys.GetAllForUser(r.Context(), owner.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+}
+
+// deleteAPIKeyHandler revokes an API key of the current user by its id. A request authenticated
+// with an API key may only revoke that key, not the other keys of its owner.
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if key := app.contextGetAPIKey(r); key != nil && key.ID != int64(id) {
+		app.apiKeyNotAllowedResponse(w, r)
+		return
+	}
+
+	app.deleteAPIKey(w, r, app.contextGetUser(r), int64(id))
+}
+
+// deleteUserAPIKeyHandler revokes an API key of a user by its id.
+func (app *application) deleteUserAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	owner, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["key_id"], 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	app.deleteAPIKey(w, r, owner, id)
+}
+
+// deleteAPIKey revokes the API key id of owner. A key may revoke itself, which lets a service
+// whose key leaked cut off access at once.
+func (app *application) deleteAPIKey(w http.ResponseWriter, r *http.Request, owner *model.User, id int64) {
+	err := app.models.APIKeys.DeleteForUser(r.Context(), owner.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.logger.PrintInfo("api key revoked", map[string]string{
+		"api_key_id": strconv.FormatInt(id, 10),
+		"user_id":    strconv.FormatInt(owner.ID, 10),
+		"revoked_by": strconv.FormatInt(app.contextGetUser(r).ID, 10),
+	})
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "api key revoked"}, nil)
+}
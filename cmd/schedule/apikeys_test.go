@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/21b030939/golang-project/pkg/schedule/model"
+)
+
+func TestParseAllowedIP(t *testing.T) {
+	tests := []struct {
+		input  string
+		want   string
+		wantOK bool
+	}{
+		{"192.0.2.1", "192.0.2.1/32", true},
+		{"2001:db8::1", "2001:db8::1/128", true},
+		{"::ffff:192.0.2.1", "192.0.2.1/32", true},
+		{"192.0.2.0/24", "192.0.2.0/24", true},
+		{"192.0.2.77/24", "192.0.2.0/24", true},
+		{"2001:db8::1/32", "2001:db8::/32", true},
+		{"0.0.0.0/0", "0.0.0.0/0", true},
+		{"", "", false},
+		{"localhost", "", false},
+		{"192.0.2.256", "", false},
+		{"192.0.2.0/33", "", false},
+		{"192.0.2.0/", "", false},
+		{" 192.0.2.1", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseAllowedIP(tt.input)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseAllowedIP(%q) = %q, %v, want %q, %v", tt.input, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestAPIKeyAllowsIP(t *testing.T) {
+	restricted := &model.APIKey{AllowedIPs: []string{"192.0.2.0/24", "2001:db8::1/128"}}
+
+	tests := []struct {
+		name string
+		key  *model.APIKey
+		ip   string
+		want bool
+	}{
+		{"unrestricted", &model.APIKey{}, "198.51.100.7", true},
+		{"unrestricted with an invalid address", &model.APIKey{}, "", true},
+		{"in network", restricted, "192.0.2.200", true},
+		{"single address", restricted, "2001:db8::1", true},
+		{"IPv4-mapped address", restricted, "::ffff:192.0.2.10", true},
+		{"outside network", restricted, "192.0.3.1", false},
+		{"other IPv6 address", restricted, "2001:db8::2", false},
+		{"invalid address", restricted, "not-an-ip", false},
+		{"empty address", restricted, "", false},
+		{"invalid stored network", &model.APIKey{AllowedIPs: []string{"garbage"}}, "192.0.2.1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := apiKeyAllowsIP(tt.key, tt.ip); got != tt.want {
+				t.Errorf("apiKeyAllowsIP(%v, %q) = %v, want %v", tt.key.AllowedIPs, tt.ip, got, tt.want)
+			}
+		})
+	}
+}
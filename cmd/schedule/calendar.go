@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/21b030939/golang-project/pkg/ical"
+	"github.com/21b030939/golang-project/pkg/schedule/model"
+	"github.com/21b030939/golang-project/pkg/schedule/validator"
+)
+
+// getScheduleCalendar renders the schedules matching the same filters as getScheduleList as an
+// iCalendar feed. Every schedule becomes a weekly recurring event for the configured term.
+func (app *application) getScheduleCalendar(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	q := app.readScheduleQuery(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	loc, err := time.LoadLocation(app.config.Calendar.Timezone)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	termStart, err := app.termStart(loc)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	cal := &ical.Calendar{
+		ProdID:   "-//golang-project//schedule " + version + "//EN",
+		Name:     "Schedule",
+		Location: loc,
+	}
+
+	err = app.forEachSchedule(r.Context(), q, func(schedule *model.Schedule) error {
+		event, ok := app.scheduleEvent(schedule, termStart)
+		if ok {
+			cal.Events = append(cal.Events, event)
+		}
+		return nil
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="schedule.ics"`)
+	if _, err := cal.WriteTo(w); err != nil {
+		app.logError(r, err)
+	}
+}
+
+// scheduleEvent converts a schedule into a recurring event. The first occurrence is the first
+// matching weekday on or after the term start, in a week of the right parity: the week the term
+// starts in is week 1, which is odd. It returns false for schedules whose time period isn't part
+// of the bell schedule, because they have no clock times.
+func (app *application) scheduleEvent(schedule *model.Schedule, termStart time.Time) (ical.Event, bool) {
+	bell, ok := app.models.Schedules.Bells[schedule.TimePeriod]
+	if !ok {
+		return ical.Event{}, false
+	}
+
+	// Monday of the week the term starts in.
+	weekStart := termStart.AddDate(0, 0, -((int(termStart.Weekday()) + 6) % 7))
+
+	day := weekStart.AddDate(0, 0, schedule.DayOfWeek-1)
+	if day.Before(termStart) {
+		day = day.AddDate(0, 0, 7)
+	}
+
+	week := int(day.Sub(weekStart).Hours()/24)/7 + 1
+	if (schedule.WeekParity == model.ParityOdd && week%2 == 0) ||
+		(schedule.WeekParity == model.ParityEven && week%2 == 1) {
+		day = day.AddDate(0, 0, 7)
+	}
+
+	interval := 1
+	if schedule.WeekParity != "" {
+		interval = 2
+	}
+
+	at := func(offset time.Duration) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), int(offset/time.Hour),
+			int(offset%time.Hour/time.Minute), 0, 0, day.Location())
+	}
+
+	event := ical.Event{
+		UID:         fmt.Sprintf("schedule-%s@golang-project", schedule.Id),
+		Summary:     schedule.Discipline,
+		Description: fmt.Sprintf("Period %d, %s-%s", schedule.TimePeriod, bell.Start, bell.End),
+		Location:    schedule.Cabinet,
+		Start:       at(bell.StartOffset()),
+		End:         at(bell.EndOffset()),
+		Recurrence: ical.Recurrence{
+			Interval: interval,
+			Until:    weekStart.AddDate(0, 0, 7*app.config.Calendar.TermWeeks),
+		},
+	}
+
+	return event, true
+}
+
+// termStart returns the configured first day of the term in loc. It's required, so that the week
+// parity and the span of the events don't move with the current date.
+func (app *application) termStart(loc *time.Location) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02", app.config.Calendar.TermStart, loc)
+}
+
+// createCalendarTokenHandler issues the secret token used to subscribe to the schedule calendar
+// feed. Issuing a new token revokes the previous one, so a leaked subscription URL can be
+// replaced.
+func (app *application) createCalendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Tokens.DeleteAllForUser(r.Context(), model.ScopeCalendar, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 365*24*time.Hour, model.ScopeCalendar)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	feed := "/api/v1/schedules.ics?" + url.Values{"token": {token.Plaintext}}.Encode()
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"calendar_token": token, "url": feed}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/21b030939/golang-project/pkg/schedule/model"
+)
+
+// TestScheduleEvent checks that the events are anchored to the configured term: the dates below
+// don't depend on the week the test runs in, like the feed doesn't depend on the week it's
+// fetched in, so odd and even classes never swap weeks.
+func TestScheduleEvent(t *testing.T) {
+	bells, err := model.ParseBellSchedule(model.DefaultBellSchedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loc := time.FixedZone("ALMT", 5*3600)
+
+	app := &application{}
+	app.models.Schedules.Bells = bells
+	// A Wednesday: the term's first week is the one of Monday 1 September.
+	app.config.Calendar.TermStart = "2025-09-03"
+	app.config.Calendar.TermWeeks = 15
+
+	date := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2025, month, day, hour, min, 0, 0, loc)
+	}
+	until := date(time.December, 15, 0, 0)
+
+	tests := []struct {
+		name         string
+		schedule     model.Schedule
+		wantStart    time.Time
+		wantInterval int
+	}{
+		{"weekly, before the term start", model.Schedule{Id: "1", DayOfWeek: 1, TimePeriod: 1}, date(time.September, 8, 8, 0), 1},
+		{"weekly, on the term start", model.Schedule{Id: "2", DayOfWeek: 3, TimePeriod: 2}, date(time.September, 3, 9, 0), 1},
+		{"odd, in week 1", model.Schedule{Id: "3", DayOfWeek: 4, TimePeriod: 1, WeekParity: model.ParityOdd}, date(time.September, 4, 8, 0), 2},
+		{"even, in week 1", model.Schedule{Id: "4", DayOfWeek: 4, TimePeriod: 1, WeekParity: model.ParityEven}, date(time.September, 11, 8, 0), 2},
+		{"odd, before the term start", model.Schedule{Id: "5", DayOfWeek: 1, TimePeriod: 1, WeekParity: model.ParityOdd}, date(time.September, 15, 8, 0), 2},
+		{"even, before the term start", model.Schedule{Id: "6", DayOfWeek: 1, TimePeriod: 1, WeekParity: model.ParityEven}, date(time.September, 8, 8, 0), 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			termStart, err := app.termStart(loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			event, ok := app.scheduleEvent(&tt.schedule, termStart)
+			if !ok {
+				t.Fatal("no event")
+			}
+
+			if !event.Start.Equal(tt.wantStart) || event.End.Sub(event.Start) != 50*time.Minute {
+				t.Errorf("event from %v to %v, want it to start at %v", event.Start, event.End, tt.wantStart)
+			}
+			if event.Recurrence.Interval != tt.wantInterval || !event.Recurrence.Until.Equal(until) {
+				t.Errorf("recurrence = %+v, want interval %d until %v", event.Recurrence, tt.wantInterval, until)
+			}
+		})
+	}
+}
+
+func TestScheduleEventUnknownPeriod(t *testing.T) {
+	app := &application{}
+	app.models.Schedules.Bells = model.BellSchedule{1: {Start: "08:00", End: "08:50"}}
+
+	_, ok := app.scheduleEvent(&model.Schedule{DayOfWeek: 1, TimePeriod: 9}, time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC))
+	if ok {
+		t.Error("got an event for a period outside the bell schedule")
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/21b030939/golang-project/pkg/jwtauth"
+	"github.com/21b030939/golang-project/pkg/schedule/model"
+)
+
+// envVarPrefix is the prefix of the environment variables that configure the application. Each
+// flag maps to the upper-cased flag name with dashes replaced by underscores, so -db-dsn is read
+// from APP_DB_DSN.
+const envVarPrefix = "APP"
+
+// maxRefreshGrace bounds -token-refresh-grace: the grace period is for retries of a refresh
+// whose response was lost, and a thief using a stolen refresh token within it isn't detected.
+const maxRefreshGrace = 5 * time.Minute
+
+// maxJWTAccessTTL bounds -jwt-access-ttl. Signed access tokens can't be revoked, and carry the
+// permissions the user had when they were issued, so they must be short-lived.
+const maxJWTAccessTTL = time.Hour
+
+// secretFlags maps the flags holding secrets to the function that redacts them for
+// -print-config.
+var secretFlags = map[string]func(string) string{
+	"db-dsn":        redactDSN,
+	"smtp-password": redactAll,
+	"jwt-keys":      jwtauth.Redact,
+}
+
+// registerFlags defines a flag on fs for every configuration value, with its default.
+func registerFlags(fs *flag.FlagSet, cfg *config) {
+	fs.BoolVar(&cfg.Fill, "fill", false, "Fill Database with some data")
+	fs.IntVar(&cfg.Port, "port", 8080, "API server port")
+	fs.StringVar(&cfg.Env, "env", "development", "Environment (development|staging|production)")
+	fs.StringVar(&cfg.DB.DSN, "db-dsn", "host=db port=5432 user=postgres dbname=schedule password=postgres sslmode=disable", "PostgreSQL DSN")
+	fs.IntVar(&cfg.DB.MaxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections (0 means unlimited)")
+	fs.IntVar(&cfg.DB.MaxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	fs.DurationVar(&cfg.DB.MaxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time (0 means unlimited)")
+	fs.DurationVar(&cfg.DB.QueryTimeout, "db-query-timeout", 3*time.Second, "Maximum duration of a single database query")
+	fs.DurationVar(&cfg.DB.ImportTimeout, "db-import-timeout", 30*time.Second, "Maximum duration of a CSV schedule import")
+	fs.DurationVar(&cfg.Tokens.AccessTTL, "token-access-ttl", 24*time.Hour, "Lifetime of authentication tokens")
+	fs.DurationVar(&cfg.Tokens.RefreshTTL, "token-refresh-ttl", 30*24*time.Hour, "Lifetime of refresh tokens")
+	fs.DurationVar(&cfg.Tokens.RefreshGrace, "token-refresh-grace", 30*time.Second, "Time during which a used refresh token returns the same pair again instead of revoking the session")
+	fs.StringVar(&cfg.Auth.Mode, "auth-mode", authModeStateful, "Authentication mode (stateful|jwt)")
+	fs.Var((*spaceSeparated)(&cfg.Auth.JWTKeys), "jwt-keys", "Space separated kid=alg:base64 keys verifying access tokens in jwt mode, alg is hs256, ed25519 or ed25519-public")
+	fs.StringVar(&cfg.Auth.JWTSigningKey, "jwt-signing-key", "", "ID of the key signing access tokens in jwt mode, defaults to the first key")
+	fs.StringVar(&cfg.Auth.JWTIssuer, "jwt-issuer", "schedule", "Issuer of access tokens in jwt mode")
+	fs.DurationVar(&cfg.Auth.JWTAccessTTL, "jwt-access-ttl", 15*time.Minute, "Lifetime of access tokens in jwt mode, during which they can't be revoked")
+	fs.StringVar(&cfg.TOTP.Issuer, "totp-issuer", "Schedule", "Name of the service shown in authenticator apps")
+	fs.BoolVar(&cfg.Login.Enabled, "login-throttle-enabled", true, "Throttle and lock out failed logins")
+	fs.IntVar(&cfg.Login.MaxFailures, "login-max-failures", 10, "Failed logins for an email address before it's locked out")
+	fs.IntVar(&cfg.Login.IPMaxFailures, "login-ip-max-failures", 100, "Failed logins from a client address before it's locked out")
+	fs.DurationVar(&cfg.Login.Backoff, "login-backoff", time.Second, "Delay after the second failed login for an email address, doubled with every failure")
+	fs.DurationVar(&cfg.Login.Lockout, "login-lockout", 15*time.Minute, "Duration of lockouts, and how long failed logins are remembered")
+	fs.BoolVar(&cfg.AutoMigrate, "auto-migrate", false, "Apply pending database migrations at startup")
+	fs.StringVar(&cfg.Bells, "bell-schedule", model.DefaultBellSchedule, "Bell schedule as period=start-end pairs, e.g. 1=08:00-08:50,2=09:00-09:50")
+	fs.StringVar(&cfg.Calendar.TermStart, "calendar-term-start", "", "First day of the term as YYYY-MM-DD, the week it starts in is week 1 (required)")
+	fs.IntVar(&cfg.Calendar.TermWeeks, "calendar-term-weeks", 15, "Number of weeks in the term")
+	fs.StringVar(&cfg.Calendar.Timezone, "calendar-timezone", "Asia/Almaty", "IANA time zone of the bell schedule")
+	fs.IntVar(&cfg.Cache.Size, "cache-size", 10000, "Maximum number of cached token and permission lookups of each kind (0 disables the cache)")
+	fs.DurationVar(&cfg.Cache.TTL, "cache-ttl", time.Minute, "Maximum time a token or permission lookup is cached")
+	fs.BoolVar(&cfg.Limiter.Enabled, "limiter-enabled", true, "Enable rate limiting")
+	fs.Float64Var(&cfg.Limiter.RPS, "limiter-rps", 2, "Rate limiter maximum requests per second per client")
+	fs.IntVar(&cfg.Limiter.Burst, "limiter-burst", 4, "Rate limiter maximum burst per client")
+	fs.Float64Var(&cfg.Limiter.GlobalRPS, "limiter-global-rps", 100, "Rate limiter maximum requests per second across all clients (0 disables the global limit)")
+	fs.IntVar(&cfg.Limiter.GlobalBurst, "limiter-global-burst", 200, "Rate limiter maximum burst across all clients")
+	fs.Var((*spaceSeparated)(&cfg.CORS.TrustedOrigins), "cors-trusted-origins", "Trusted CORS origins (space separated)")
+	fs.DurationVar(&cfg.ShutdownDelay, "shutdown-delay", 0, "Time to keep serving after a shutdown signal while /readyz reports unavailable")
+	fs.StringVar(&cfg.Mailer, "mailer", "stdout", "Email delivery (smtp|file|stdout)")
+	fs.StringVar(&cfg.MailerFile, "mailer-file", "tmp/mail.log", "File emails are appended to with -mailer=file")
+	fs.StringVar(&cfg.SMTP.Host, "smtp-host", "localhost", "SMTP host")
+	fs.IntVar(&cfg.SMTP.Port, "smtp-port", 25, "SMTP port")
+	fs.StringVar(&cfg.SMTP.Username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.SMTP.Password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.SMTP.Sender, "smtp-sender", "Schedule <no-reply@schedule.local>", "SMTP sender")
+}
+
+// validate checks the whole configuration and reports every invalid value at once, so that a
+// broken deployment can be fixed in one go.
+func (cfg config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(cfg.Port > 0 && cfg.Port <= 65535, "port: must be between 1 and 65535")
+	check(cfg.DB.DSN != "", "db-dsn: must be provided")
+	check(cfg.DB.MaxOpenConns >= 0, "db-max-open-conns: must not be negative")
+	check(cfg.DB.MaxIdleConns >= 0, "db-max-idle-conns: must not be negative")
+	check(cfg.DB.MaxOpenConns == 0 || cfg.DB.MaxIdleConns <= cfg.DB.MaxOpenConns,
+		"db-max-idle-conns: must not be greater than db-max-open-conns")
+	check(cfg.DB.MaxIdleTime >= 0, "db-max-idle-time: must not be negative")
+	check(cfg.DB.QueryTimeout > 0, "db-query-timeout: must be greater than zero")
+	check(cfg.DB.ImportTimeout >= cfg.DB.QueryTimeout, "db-import-timeout: must not be shorter than db-query-timeout")
+
+	check(cfg.Tokens.AccessTTL > 0, "token-access-ttl: must be greater than zero")
+	check(cfg.Tokens.RefreshTTL >= cfg.Tokens.AccessTTL, "token-refresh-ttl: must not be shorter than token-access-ttl")
+	check(cfg.Tokens.RefreshGrace >= 0 && cfg.Tokens.RefreshGrace <= maxRefreshGrace,
+		"token-refresh-grace: must be between 0 and %s", maxRefreshGrace)
+
+	switch cfg.Auth.Mode {
+	case authModeStateful:
+	case authModeJWT:
+		if _, err := cfg.jwtKeySet(); err != nil {
+			errs = append(errs, fmt.Errorf("jwt-keys: %w", err))
+		}
+		check(cfg.Auth.JWTAccessTTL > 0 && cfg.Auth.JWTAccessTTL <= maxJWTAccessTTL,
+			"jwt-access-ttl: must be between 0 and %s", maxJWTAccessTTL)
+		check(cfg.Tokens.RefreshTTL >= cfg.Auth.JWTAccessTTL, "token-refresh-ttl: must not be shorter than jwt-access-ttl")
+	default:
+		errs = append(errs, fmt.Errorf("auth-mode: must be stateful or jwt"))
+	}
+
+	if cfg.Login.Enabled {
+		check(cfg.Login.MaxFailures > 0, "login-max-failures: must be greater than zero")
+		check(cfg.Login.IPMaxFailures > 0, "login-ip-max-failures: must be greater than zero")
+		check(cfg.Login.Backoff >= 0, "login-backoff: must not be negative")
+		check(cfg.Login.Lockout > 0, "login-lockout: must be greater than zero")
+	}
+
+	check(cfg.TOTP.Issuer != "" && !strings.Contains(cfg.TOTP.Issuer, ":"), "totp-issuer: must be provided and must not contain a colon")
+
+	if _, err := model.ParseBellSchedule(cfg.Bells); err != nil {
+		errs = append(errs, fmt.Errorf("bell-schedule: %w", err))
+	}
+
+	if _, err := time.LoadLocation(cfg.Calendar.Timezone); err != nil {
+		errs = append(errs, fmt.Errorf("calendar-timezone: %w", err))
+	}
+	// Odd and even weeks are counted from the term start, so without one the parity of the
+	// events would change from one week to the next.
+	_, err := time.Parse("2006-01-02", cfg.Calendar.TermStart)
+	check(err == nil, "calendar-term-start: must be a date in YYYY-MM-DD format")
+	check(cfg.Calendar.TermWeeks > 0, "calendar-term-weeks: must be greater than zero")
+
+	check(cfg.Cache.Size >= 0, "cache-size: must not be negative")
+	check(cfg.Cache.Size == 0 || cfg.Cache.TTL > 0, "cache-ttl: must be greater than zero")
+
+	if cfg.Limiter.Enabled {
+		check(cfg.Limiter.RPS > 0, "limiter-rps: must be greater than zero")
+		check(cfg.Limiter.Burst > 0, "limiter-burst: must be greater than zero")
+		check(cfg.Limiter.GlobalRPS <= 0 || cfg.Limiter.GlobalBurst > 0, "limiter-global-burst: must be greater than zero")
+	}
+
+	for _, origin := range cfg.CORS.TrustedOrigins {
+		u, err := url.Parse(origin)
+		check(err == nil && u.Scheme != "" && u.Host != "" && u.Path == "",
+			"cors-trusted-origins: %q must be an origin such as https://example.com", origin)
+	}
+
+	check(cfg.ShutdownDelay >= 0, "shutdown-delay: must not be negative")
+
+	switch cfg.Mailer {
+	case "smtp":
+		check(cfg.SMTP.Host != "", "smtp-host: must be provided")
+		check(cfg.SMTP.Port > 0 && cfg.SMTP.Port <= 65535, "smtp-port: must be between 1 and 65535")
+	case "file":
+		check(cfg.MailerFile != "", "mailer-file: must be provided")
+	case "stdout":
+	default:
+		errs = append(errs, fmt.Errorf("mailer: must be smtp, file or stdout"))
+	}
+	// The file and stdout mailers write the activation and password reset tokens in plain text
+	// where anyone with access to the logs can read them.
+	check(cfg.Env != "production" || cfg.Mailer == "smtp", "mailer: must be smtp in production")
+	if _, err := mail.ParseAddress(cfg.SMTP.Sender); err != nil {
+		errs = append(errs, fmt.Errorf("smtp-sender: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// accessTTL returns the lifetime of the authentication tokens issued in the configured auth mode.
+func (cfg config) accessTTL() time.Duration {
+	if cfg.Auth.Mode == authModeJWT {
+		return cfg.Auth.JWTAccessTTL
+	}
+
+	return cfg.Tokens.AccessTTL
+}
+
+// jwtKeySet returns the keys signing and verifying access tokens in jwt mode, or nil in stateful
+// mode.
+func (cfg config) jwtKeySet() (*jwtauth.KeySet, error) {
+	if cfg.Auth.Mode != authModeJWT {
+		return nil, nil
+	}
+
+	return jwtauth.ParseKeys(cfg.Auth.JWTKeys, cfg.Auth.JWTSigningKey, cfg.Auth.JWTIssuer)
+}
+
+// printConfig writes the value of every flag in the format of the config file, with secrets
+// redacted.
+func printConfig(w io.Writer, fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "print-config" {
+			return
+		}
+
+		value := f.Value.String()
+		if redact, ok := secretFlags[f.Name]; ok && value != "" {
+			value = redact(value)
+		}
+
+		fmt.Fprintf(w, "%s %s\n", f.Name, value)
+	})
+}
+
+func redactAll(string) string {
+	return "xxxxx"
+}
+
+var dsnPasswordRX = regexp.MustCompile(`password=\S+`)
+
+// redactDSN hides the password of a DSN, in either URL or key=value format.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+
+	return dsnPasswordRX.ReplaceAllString(dsn, "password=xxxxx")
+}
+
+// spaceSeparated is a flag.Value holding a list of values separated by spaces.
+type spaceSeparated []string
+
+func (s *spaceSeparated) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *spaceSeparated) Set(value string) error {
+	*s = strings.Fields(value)
+	return nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/ff/v3"
+)
+
+// defaultConfig returns the configuration built from the defaults of the flags.
+func defaultConfig(t *testing.T) config {
+	t.Helper()
+
+	var cfg config
+	fs := flag.NewFlagSet("schedule", flag.ContinueOnError)
+	registerFlags(fs, &cfg)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The term start has no default.
+	cfg.Calendar.TermStart = "2025-09-01"
+
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	hs256Key := "k1=hs256:" + strings.Repeat("A", 44)
+
+	tests := []struct {
+		name    string
+		modify  func(cfg *config)
+		wantErr []string
+	}{
+		{name: "defaults", modify: func(cfg *config) {}},
+		{
+			name: "production with smtp",
+			modify: func(cfg *config) {
+				cfg.Env = "production"
+				cfg.Mailer = "smtp"
+			},
+		},
+		{
+			name: "jwt mode",
+			modify: func(cfg *config) {
+				cfg.Auth.Mode = authModeJWT
+				cfg.Auth.JWTKeys = []string{hs256Key}
+			},
+		},
+		{
+			name: "every error at once",
+			modify: func(cfg *config) {
+				cfg.Port = 0
+				cfg.DB.DSN = ""
+			},
+			wantErr: []string{"port:", "db-dsn:"},
+		},
+		{
+			name: "idle connections over open connections",
+			modify: func(cfg *config) {
+				cfg.DB.MaxOpenConns = 5
+				cfg.DB.MaxIdleConns = 10
+			},
+			wantErr: []string{"db-max-idle-conns:"},
+		},
+		{
+			name:    "refresh tokens shorter than access tokens",
+			modify:  func(cfg *config) { cfg.Tokens.RefreshTTL = time.Hour },
+			wantErr: []string{"token-refresh-ttl:"},
+		},
+		{
+			name:    "refresh grace too long",
+			modify:  func(cfg *config) { cfg.Tokens.RefreshGrace = time.Hour },
+			wantErr: []string{"token-refresh-grace:"},
+		},
+		{
+			name:    "unknown auth mode",
+			modify:  func(cfg *config) { cfg.Auth.Mode = "session" },
+			wantErr: []string{"auth-mode:"},
+		},
+		{
+			name: "jwt access tokens too long-lived",
+			modify: func(cfg *config) {
+				cfg.Auth.Mode = authModeJWT
+				cfg.Auth.JWTKeys = []string{hs256Key}
+				cfg.Auth.JWTAccessTTL = 24 * time.Hour
+			},
+			wantErr: []string{"jwt-access-ttl:"},
+		},
+		{
+			name:    "jwt mode without keys",
+			modify:  func(cfg *config) { cfg.Auth.Mode = authModeJWT },
+			wantErr: []string{"jwt-keys:"},
+		},
+		{
+			name:    "invalid bell schedule",
+			modify:  func(cfg *config) { cfg.Bells = "1=08:00" },
+			wantErr: []string{"bell-schedule:"},
+		},
+		{
+			name:    "unknown time zone",
+			modify:  func(cfg *config) { cfg.Calendar.Timezone = "Mars/Olympus_Mons" },
+			wantErr: []string{"calendar-timezone:"},
+		},
+		{
+			name:    "import timeout shorter than the query timeout",
+			modify:  func(cfg *config) { cfg.DB.ImportTimeout = time.Second },
+			wantErr: []string{"db-import-timeout:"},
+		},
+		{
+			name:    "no term start",
+			modify:  func(cfg *config) { cfg.Calendar.TermStart = "" },
+			wantErr: []string{"calendar-term-start:"},
+		},
+		{
+			name:    "invalid term start",
+			modify:  func(cfg *config) { cfg.Calendar.TermStart = "01.09.2025" },
+			wantErr: []string{"calendar-term-start:"},
+		},
+		{
+			name:    "cors origin with a path",
+			modify:  func(cfg *config) { cfg.CORS.TrustedOrigins = []string{"https://example.com/app"} },
+			wantErr: []string{"cors-trusted-origins:"},
+		},
+		{
+			name:    "unknown mailer",
+			modify:  func(cfg *config) { cfg.Mailer = "pigeon" },
+			wantErr: []string{"mailer: must be smtp, file or stdout"},
+		},
+		{
+			name:    "stdout mailer in production",
+			modify:  func(cfg *config) { cfg.Env = "production" },
+			wantErr: []string{"mailer: must be smtp in production"},
+		},
+		{
+			name: "file mailer in production",
+			modify: func(cfg *config) {
+				cfg.Env = "production"
+				cfg.Mailer = "file"
+			},
+			wantErr: []string{"mailer: must be smtp in production"},
+		},
+		{
+			name:    "invalid sender",
+			modify:  func(cfg *config) { cfg.SMTP.Sender = "not an address" },
+			wantErr: []string{"smtp-sender:"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig(t)
+			tt.modify(&cfg)
+
+			err := cfg.validate()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("expected errors for %v", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error doesn't contain %q: %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestAccessTTL(t *testing.T) {
+	cfg := defaultConfig(t)
+	if got := cfg.accessTTL(); got != 24*time.Hour {
+		t.Errorf("stateful mode: accessTTL() = %v, want 24h", got)
+	}
+
+	cfg.Auth.Mode = authModeJWT
+	if got := cfg.accessTTL(); got != 15*time.Minute {
+		t.Errorf("jwt mode: accessTTL() = %v, want 15m", got)
+	}
+}
+
+func TestConfigSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedule.conf")
+	err := os.WriteFile(path, []byte("port 9000\nlimiter-rps 5\ncors-trusted-origins https://a.example https://b.example\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("APP_LIMITER_RPS", "7")
+	t.Setenv("APP_DB_MAX_OPEN_CONNS", "10")
+
+	var cfg config
+	fs := flag.NewFlagSet("schedule", flag.ContinueOnError)
+	registerFlags(fs, &cfg)
+	fs.String("config", "", "")
+
+	err = ff.Parse(fs, []string{"-config", path, "-db-max-open-conns", "12"},
+		ff.WithEnvVarPrefix(envVarPrefix),
+		ff.WithConfigFileFlag("config"),
+		ff.WithConfigFileParser(ff.PlainParser),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flags take precedence over environment variables, which take precedence over the config
+	// file, which takes precedence over the defaults.
+	if cfg.DB.MaxOpenConns != 12 {
+		t.Errorf("db-max-open-conns = %d, want the flag value 12", cfg.DB.MaxOpenConns)
+	}
+	if cfg.Limiter.RPS != 7 {
+		t.Errorf("limiter-rps = %v, want the environment value 7", cfg.Limiter.RPS)
+	}
+	if cfg.Port != 9000 {
+		t.Errorf("port = %d, want the config file value 9000", cfg.Port)
+	}
+	if cfg.Limiter.Burst != 4 {
+		t.Errorf("limiter-burst = %d, want the default 4", cfg.Limiter.Burst)
+	}
+	if got := strings.Join(cfg.CORS.TrustedOrigins, ","); got != "https://a.example,https://b.example" {
+		t.Errorf("cors-trusted-origins = %q", got)
+	}
+}
+
+func TestRedactDSN(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want string
+	}{
+		{"postgres://schedule:s3cret@db:5432/schedule?sslmode=disable", "postgres://schedule:xxxxx@db:5432/schedule?sslmode=disable"},
+		{"postgres://db:5432/schedule", "postgres://db:5432/schedule"},
+		{"host=db user=postgres password=s3cret dbname=schedule", "host=db user=postgres password=xxxxx dbname=schedule"},
+		{"host=db user=postgres dbname=schedule", "host=db user=postgres dbname=schedule"},
+	}
+
+	for _, tt := range tests {
+		if got := redactDSN(tt.dsn); got != tt.want {
+			t.Errorf("redactDSN(%q) = %q, want %q", tt.dsn, got, tt.want)
+		}
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	var cfg config
+	fs := flag.NewFlagSet("schedule", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	registerFlags(fs, &cfg)
+	fs.String("config", "", "")
+
+	err := fs.Parse([]string{
+		"-config", "schedule.conf",
+		"-smtp-password", "mailpass",
+		"-jwt-keys", "k1=hs256:c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0 k2=ed25519-public:cHVibGlj",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	printConfig(&b, fs)
+	out := b.String()
+
+	for _, secret := range []string{"mailpass", "password=postgres", "c2VjcmV0", "cHVibGlj"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("output contains the secret %q:\n%s", secret, out)
+		}
+	}
+	for _, want := range []string{
+		"\nport 8080\n",
+		"\nsmtp-password xxxxx\n",
+		"password=xxxxx",
+		"\njwt-keys k1=hs256:xxxxx k2=ed25519-public:xxxxx\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output doesn't contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "config schedule.conf") {
+		t.Errorf("output contains the config flag:\n%s", out)
+	}
+}
+
+func TestSpaceSeparated(t *testing.T) {
+	var s spaceSeparated
+	if err := s.Set("  https://a.example\thttps://b.example  "); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s) != 2 || s[0] != "https://a.example" || s[1] != "https://b.example" {
+		t.Errorf("got %q", []string(s))
+	}
+	if got := s.String(); got != "https://a.example https://b.example" {
+		t.Errorf("String() = %q", got)
+	}
+}
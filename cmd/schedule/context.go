@@ -9,7 +9,14 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey  = contextKey("user")
+	tokenContextKey = contextKey("token")
+	// permissionsContextKey holds the permission codes carried by a signed access token.
+	permissionsContextKey = contextKey("permissions")
+	// apiKeyContextKey holds the API key the request was authenticated with.
+	apiKeyContextKey = contextKey("api-key")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *model.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -23,4 +30,44 @@ func (app *application) contextGetUser(r *http.Request) *model.User {
 	}
 
 	return user
-}
\ No newline at end of file
+}
+
+// contextSetToken stores the authentication token the request was authenticated with.
+func (app *application) contextSetToken(r *http.Request, token *model.Token) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// contextGetToken returns the authentication token the request was authenticated with, or nil
+// if the request wasn't authenticated with a token.
+func (app *application) contextGetToken(r *http.Request) *model.Token {
+	token, _ := r.Context().Value(tokenContextKey).(*model.Token)
+	return token
+}
+
+// contextSetPermissions stores the permission codes of the user, when the request was
+// authenticated with a token that carries them.
+func (app *application) contextSetPermissions(r *http.Request, permissions model.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// contextGetPermissions returns the permission codes stored by contextSetPermissions. The boolean
+// is false if they have to be looked up in the database instead.
+func (app *application) contextGetPermissions(r *http.Request) (model.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsContextKey).(model.Permissions)
+	return permissions, ok
+}
+
+// contextSetAPIKey stores the API key the request was authenticated with.
+func (app *application) contextSetAPIKey(r *http.Request, key *model.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key the request was authenticated with, or nil if the request
+// wasn't authenticated with an API key.
+func (app *application) contextGetAPIKey(r *http.Request) *model.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*model.APIKey)
+	return key
+}
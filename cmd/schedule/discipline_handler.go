@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/21b030939/golang-project/pkg/schedule/model"
+	"github.com/21b030939/golang-project/pkg/schedule/validator"
+)
+
+func (app *application) createDisciplineHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Credits     string `json:"credits"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	discipline := &model.Discipline{
+		Name:        input.Name,
+		Description: input.Description,
+		Credits:     input.Credits,
+	}
+
+	v := validator.New()
+
+	if model.ValidateDiscipline(v, discipline); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Disciplines.Insert(r.Context(), discipline)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"discipline": discipline}, nil)
+}
+
+func (app *application) getDisciplineList(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name    string
+		Credits string
+		model.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	// Use our helpers to extract the name and credits query string values, falling back to
+	// empty strings (i.e. no filtering) if they are not provided by the client.
+	input.Name = app.readStrings(qs, "name", "")
+	input.Credits = app.readStrings(qs, "credits", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readStrings(qs, "sort", "id")
+	input.Filters.SortSafeList = []string{
+		// ascending sort values
+		"id", "name", "credits",
+		// descending sort values
+		"-id", "-name", "-credits",
+	}
+
+	if model.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	disciplines, metadata, err := app.models.Disciplines.GetAll(r.Context(), input.Name, input.Credits, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"disciplines": disciplines, "metadata": metadata}, nil)
+}
+
+func (app *application) getDisciplineHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	discipline, err := app.models.Disciplines.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"discipline": discipline}, nil)
+}
+
+func (app *application) updateDisciplineHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	discipline, err := app.models.Disciplines.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Version is optional. If the client sends the version it read the record at, we make sure
+	// nobody else has changed it since; otherwise the version we just fetched is used.
+	var input struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		Credits     *string `json:"credits"`
+		Version     *int    `json:"version"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Version != nil && *input.Version != discipline.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.Name != nil {
+		discipline.Name = *input.Name
+	}
+
+	if input.Description != nil {
+		discipline.Description = *input.Description
+	}
+
+	if input.Credits != nil {
+		discipline.Credits = *input.Credits
+	}
+
+	v := validator.New()
+
+	if model.ValidateDiscipline(v, discipline); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Disciplines.Update(r.Context(), discipline)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"discipline": discipline}, nil)
+}
+
+func (app *application) deleteDisciplineHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Disciplines.Delete(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
+}
+
+// getDisciplineSchedulesHandler lists every schedule slot linked to a specific discipline.
+func (app *application) getDisciplineSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	discipline, err := app.models.Disciplines.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var filters model.Filters
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 100, v)
+	filters.Sort = app.readStrings(qs, "sort", "day_of_week")
+	filters.SortSafeList = []string{
+		"id", "cabinet", "day_of_week", "time_period",
+		"-id", "-cabinet", "-day_of_week", "-time_period",
+	}
+
+	if model.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	schedules, metadata, err := app.models.Schedules.GetAll(r.Context(), "", id, 0, 0, 0, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"discipline": discipline, "schedules": schedules, "metadata": metadata}, nil)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDisciplineHandlers(t *testing.T) {
+	serve := newTestRouter(t, "disciplines:write")
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{"create with a malformed body", http.MethodPost, "/api/v1/disciplines", `{"credits": 3}`, http.StatusBadRequest, ""},
+		{"create an invalid discipline", http.MethodPost, "/api/v1/disciplines", `{"credits": "31"}`, http.StatusUnprocessableEntity, "must be provided"},
+		{"create a valid discipline", http.MethodPost, "/api/v1/disciplines", `{"name": "Calculus II", "credits": "3"}`, http.StatusInternalServerError, ""},
+		{"list with an unknown sort", http.MethodGet, "/api/v1/disciplines?sort=description", "", http.StatusUnprocessableEntity, "invalid sort value"},
+		{"list with a large page size", http.MethodGet, "/api/v1/disciplines?page_size=101", "", http.StatusUnprocessableEntity, "must be a maximum of 100"},
+		{"list", http.MethodGet, "/api/v1/disciplines?name=calc&sort=-credits", "", http.StatusInternalServerError, ""},
+		{"get an invalid id", http.MethodGet, "/api/v1/disciplines/0", "", http.StatusNotFound, ""},
+		{"update an invalid id", http.MethodPut, "/api/v1/disciplines/0", `{"name": "Calculus II"}`, http.StatusNotFound, ""},
+		{"delete an invalid id", http.MethodDelete, "/api/v1/disciplines/0", "", http.StatusNotFound, ""},
+		{"delete", http.MethodDelete, "/api/v1/disciplines/1", "", http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.method, tt.path, tt.body)
+
+			if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("status = %d, body = %s, want %d containing %q", w.Code, w.Body, tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+}
@@ -2,15 +2,22 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // logError method is a generic helper for logging an error message in *application, as well
 // as the requested method and request URL.
 func (app *application) logError(r *http.Request, err error) {
+	// Leave the query string out, since it can hold secrets such as calendar tokens.
+	u := *r.URL
+	u.RawQuery = ""
+
 	app.logger.PrintError(err, map[string]string{
 		"request_method": r.Method,
-		"request_url":    r.URL.String(),
+		"request_url":    u.String(),
 	})
 }
 
@@ -76,6 +83,23 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+// scheduleConflictResponse sends a JSON-formatted error message to the client with a 409 Conflict
+// status code, listing the schedules that already occupy the requested cabinet and time period.
+func (app *application) scheduleConflictResponse(w http.ResponseWriter, r *http.Request, ids []int64) {
+	message := envelope{
+		"message":   "the cabinet is already booked for this time period",
+		"conflicts": ids,
+	}
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// totpEnabledResponse sends a JSON-formatted error message to the client with a 409 Conflict
+// status code.
+func (app *application) totpEnabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication is already enabled"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
 // invalidCredentialsResponse sends a JSON-formatted error with a 401 Unauthorized status code
 // to the client.
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
@@ -92,6 +116,20 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// invalidTwoFactorCodeResponse sends a JSON-formatted error with a 401 Unauthorized status code
+// to the client.
+func (app *application) invalidTwoFactorCodeResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or already used two-factor authentication code"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// twoFactorAttemptsExceededResponse sends a 401 Unauthorized response once too many wrong codes
+// were sent to disable two-factor authentication, and every session of the user was revoked.
+func (app *application) twoFactorAttemptsExceededResponse(w http.ResponseWriter, r *http.Request) {
+	message := "too many invalid two-factor authentication codes, you have been logged out of every session"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
 // authenticationRequiredResponse sends a JSON-formatted error with a 401 Unauthorized status code
 // to the client.
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
@@ -106,7 +144,32 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+// apiKeyNotAllowedResponse sends a 403 Forbidden response to a request authenticated with an API
+// key for a resource only the user themselves may access.
+func (app *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can't be accessed with an API key, you must log in"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+// rateLimitExceededResponse sends a 429 Too Many Requests response, telling the client in the
+// Retry-After header how many seconds to wait before trying again.
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "rate limit exceeded"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// loginThrottledResponse sends a 429 Too Many Requests response to a login attempt refused after
+// too many failed ones, telling the client in the Retry-After header how many seconds to wait.
+func (app *application) loginThrottledResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"time"
 )
 
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
@@ -10,6 +13,10 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 			"environment": app.config.Env,
 			"version":     version},
 	}
+	// Report the hit and miss counters of the token and permission caches, if enabled.
+	if stats := app.models.Users.Cache.Stats(); stats != nil {
+		env["cache"] = stats
+	}
 	// Add a 10 second delay to demonstrate the server returning a response after shutting down
 	// time.Sleep(10 * time.Second)
 	err := app.writeJSON(w, http.StatusOK, env, nil)
@@ -17,3 +24,81 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// livezHandler reports that the process is up and serving requests. It doesn't check any
+// dependencies, so that an unavailable database doesn't get every instance restarted.
+func (app *application) livezHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// component is the state of one of the things readyzHandler checks.
+type component struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// readyzHandler reports whether the instance should receive traffic: the server must not be
+// shutting down, the database must answer within a second and its schema must be at least at
+// the version of the newest embedded migration. A newer schema is expected during a rolling
+// deploy, once a new instance with -auto-migrate has migrated it. It responds with 503 Service
+// Unavailable if any of these checks fail, listing the state of every component. The probe isn't
+// authenticated, so database errors are logged rather than returned.
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	components := map[string]component{
+		"server":     {Status: "ok"},
+		"database":   {Status: "ok"},
+		"migrations": {Status: "ok"},
+	}
+
+	if app.shuttingDown.Load() {
+		components["server"] = component{Status: "unavailable", Error: "shutting down"}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	if err := app.models.Schema.Ping(ctx); err != nil {
+		app.logError(r, err)
+		components["database"] = component{Status: "unavailable"}
+		components["migrations"] = component{Status: "unknown"}
+	} else {
+		version, dirty, err := app.models.Schema.Version(ctx)
+		if err != nil {
+			app.logError(r, err)
+			components["migrations"] = component{Status: "unavailable"}
+		} else {
+			components["migrations"] = migrationsComponent(version, dirty, app.schemaVersion)
+		}
+	}
+
+	status, code := "available", http.StatusOK
+	for _, c := range components {
+		if c.Status != "ok" {
+			status, code = "unavailable", http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	err := app.writeJSON(w, code, envelope{"status": status, "components": components}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// migrationsComponent returns the state of a schema at version, which must not be dirty and at
+// least at the expected version.
+func migrationsComponent(version uint, dirty bool, expected uint) component {
+	switch {
+	case dirty:
+		return component{Status: "unavailable",
+			Error: fmt.Sprintf("migration %d failed and left the schema dirty", version)}
+	case version < expected:
+		return component{Status: "unavailable",
+			Error: fmt.Sprintf("schema is at version %d, expected at least %d", version, expected)}
+	}
+
+	return component{Status: "ok"}
+}
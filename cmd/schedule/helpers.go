@@ -5,10 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/21b030939/golang-project/pkg/schedule/validator"
 	"github.com/gorilla/mux"
@@ -161,6 +163,16 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	return nil
 }
 
+// remoteIP returns the IP address of the client, without the port.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
 // readStrings is a helper method on application type that returns a string value from the URL query
 // string, or the provided default value if no matching key is found.
 func (app *application) readStrings(qs url.Values, key string, defaultValue string) string {
@@ -201,3 +213,43 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	// Otherwise, return the converted integer value.
 	return i
 }
+
+// background runs fn in a background goroutine. The goroutine is tracked by app.wg, so that a
+// graceful shutdown waits for it to finish, and any panic in fn is recovered and logged instead
+// of terminating the application.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// sendEmail sends the templated email in the background. Delivery is attempted up to three
+// times, because SMTP servers commonly fail transiently. Failures are logged, since there is no
+// request left to report them to.
+func (app *application) sendEmail(recipient, templateFile string, data interface{}) {
+	app.background(func() {
+		var err error
+		for i := 1; i <= 3; i++ {
+			err = app.mailer.Send(recipient, templateFile, data)
+			if err == nil {
+				return
+			}
+			time.Sleep(500 * time.Millisecond)
+		}
+
+		app.logger.PrintError(err, map[string]string{
+			"recipient": recipient,
+			"template":  templateFile,
+		})
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/21b030939/golang-project/pkg/jwtauth"
+	"github.com/21b030939/golang-project/pkg/schedule/model"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	authModeStateful = "stateful"
+	authModeJWT      = "jwt"
+)
+
+// accessClaims are the claims of the signed access tokens issued in jwt mode. They carry
+// everything authenticate and requirePermissions need, so that requests can be authenticated
+// without a database lookup.
+type accessClaims struct {
+	jwt.RegisteredClaims
+	Activated   bool              `json:"act"`
+	Permissions model.Permissions `json:"perms"`
+	// SessionID is the family of the refresh token issued along with the access token, so that
+	// logging out can revoke it.
+	SessionID int64 `json:"sid"`
+}
+
+// accessToken returns the token the client authenticates with. In stateful mode that's the
+// opaque authentication token stored in the database. In jwt mode that token isn't stored, the
+// session is only recorded by its refresh token, and the client is given a signed token expiring
+// at the same time, which carries the user's activation status and current permission codes.
+// Those are only updated when the token is refreshed, which is why jwt mode uses the short
+// -jwt-access-ttl.
+func (app *application) accessToken(ctx context.Context, user *model.User, token *model.Token) (*model.Token, error) {
+	if app.config.Auth.Mode != authModeJWT {
+		return token, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claims := accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    app.jwtKeys.Issuer(),
+			Subject:   strconv.FormatInt(user.ID, 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(token.Expiry),
+		},
+		Activated:   user.Activated,
+		Permissions: permissions,
+		SessionID:   token.FamilyID,
+	}
+
+	signed, err := app.jwtKeys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.Token{
+		Plaintext: signed,
+		UserID:    user.ID,
+		Expiry:    token.Expiry,
+		Scope:     model.ScopeAuthentication,
+		FamilyID:  token.FamilyID,
+	}, nil
+}
+
+// verifyAccessToken checks a signed access token and returns its claims along with the user it
+// was issued to. Only the ID and activation status of the user are filled in.
+func (app *application) verifyAccessToken(tokenString string) (*accessClaims, *model.User, error) {
+	var claims accessClaims
+
+	err := app.jwtKeys.Verify(tokenString, &claims)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	id, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || id < 1 {
+		return nil, nil, jwtauth.ErrInvalidToken
+	}
+
+	return &claims, &model.User{ID: id, Activated: claims.Activated}, nil
+}
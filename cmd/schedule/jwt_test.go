@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/21b030939/golang-project/pkg/jsonlog"
+	"github.com/21b030939/golang-project/pkg/jwtauth"
+	"github.com/21b030939/golang-project/pkg/schedule/model"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TestAuthenticateJWTKeyRotation checks that tokens signed with a retired key, which is only kept
+// to verify them, are still accepted, while tokens naming a key that isn't configured are not.
+func TestAuthenticateJWTKeyRotation(t *testing.T) {
+	secret := func(c string) string {
+		return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(c, jwtauth.MinHMACKeySize)))
+	}
+	seed := []byte(strings.Repeat("e", ed25519.SeedSize))
+	public := base64.StdEncoding.EncodeToString(ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey))
+
+	keySet := func(specs ...string) *jwtauth.KeySet {
+		ks, err := jwtauth.ParseKeys(specs, "", "schedule")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ks
+	}
+
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelError)}
+	app.config.Auth.Mode = authModeJWT
+	app.jwtKeys = keySet("2025=hs256:"+secret("n"), "2024=ed25519-public:"+public)
+
+	sign := func(ks *jwtauth.KeySet) string {
+		now := time.Now()
+		token, err := ks.Sign(accessClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    "schedule",
+				Subject:   "42",
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			},
+			Activated:   true,
+			Permissions: model.Permissions{"schedules:read"},
+			SessionID:   7,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+	}{
+		{"signing key", sign(app.jwtKeys), http.StatusNoContent},
+		{"retired verify-only key", sign(keySet("2024=ed25519:" + base64.StdEncoding.EncodeToString(seed))), http.StatusNoContent},
+		{"unknown key", sign(keySet("2023=hs256:" + secret("o"))), http.StatusUnauthorized},
+		{"known kid, other secret", sign(keySet("2025=hs256:" + secret("o"))), http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var user *model.User
+			handler := app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				user = app.contextGetUser(r)
+				w.WriteHeader(http.StatusNoContent)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/schedules", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode == http.StatusNoContent && (user == nil || user.ID != 42 || !user.Activated) {
+				t.Errorf("user = %+v, want activated user 42", user)
+			}
+		})
+	}
+}
+
+func TestIsCurrentSession(t *testing.T) {
+	stored := &model.Token{Hash: []byte("hash"), FamilyID: 7}
+
+	tests := []struct {
+		name    string
+		current *model.Token
+		want    bool
+	}{
+		{"not authenticated with a token", nil, false},
+		{"same opaque token", &model.Token{Hash: []byte("hash"), FamilyID: 7}, true},
+		{"other opaque token", &model.Token{Hash: []byte("other"), FamilyID: 7}, false},
+		{"signed token of the session", &model.Token{FamilyID: 7}, true},
+		{"signed token of another session", &model.Token{FamilyID: 8}, false},
+		{"signed token without a session", &model.Token{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isCurrentSession(tt.current, stored); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if isCurrentSession(&model.Token{}, &model.Token{Hash: []byte("hash")}) {
+		t.Error("a signed token without a session matches a session without a family")
+	}
+}
+
+func TestSessionScope(t *testing.T) {
+	app := &application{}
+
+	app.config.Auth.Mode = authModeStateful
+	if got := app.sessionScope(); got != model.ScopeAuthentication {
+		t.Errorf("stateful mode: got %q, want %q", got, model.ScopeAuthentication)
+	}
+
+	app.config.Auth.Mode = authModeJWT
+	if got := app.sessionScope(); got != model.ScopeRefresh {
+		t.Errorf("jwt mode: got %q, want %q", got, model.ScopeRefresh)
+	}
+}
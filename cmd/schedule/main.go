@@ -1,17 +1,27 @@
 package main
 
 import (
+	"context"
 	// "database/sql"
 	"flag"
+	"fmt"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
+	// Embed the time zone database, the runtime image doesn't ship one.
+	_ "time/tzdata"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/21b030939/golang-project/pkg/jsonlog"
+	"github.com/21b030939/golang-project/pkg/jwtauth"
+	"github.com/21b030939/golang-project/pkg/mailer"
+	"github.com/21b030939/golang-project/pkg/schedule/migrations"
 	"github.com/21b030939/golang-project/pkg/schedule/model"
 	"github.com/21b030939/golang-project/pkg/schedule/model/filter"
 	"github.com/21b030939/golang-project/pkg/vcs"
+	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+	"github.com/peterbourgon/ff/v3"
 )
 
 // Set version of application corresponding to value of vcs.Version.
@@ -23,29 +33,171 @@ type config struct {
 	Port int
 	Env  string
 	Fill bool
-	DB   struct {
-		DSN string
+	// DB configures the connection pool. QueryTimeout bounds every query made by the models,
+	// on top of the deadline of the request that triggered it, except for CSV imports, which are
+	// bounded by ImportTimeout.
+	DB struct {
+		DSN           string
+		MaxOpenConns  int
+		MaxIdleConns  int
+		MaxIdleTime   time.Duration
+		QueryTimeout  time.Duration
+		ImportTimeout time.Duration
+	}
+	// Tokens configures the lifetime of the tokens issued at login: the authentication token
+	// sent with every request, and the refresh token exchanged for a new pair once it expires.
+	// A refresh token presented again within RefreshGrace of its use returns the same pair.
+	Tokens struct {
+		AccessTTL    time.Duration
+		RefreshTTL   time.Duration
+		RefreshGrace time.Duration
+	}
+	// Auth selects how requests are authenticated. In "stateful" mode every bearer token is
+	// looked up in the database. In "jwt" mode clients are given signed tokens, verified with
+	// JWTKeys, which carry the user's permissions; see jwtauth.ParseKeys for the key format.
+	// Signed tokens can't be revoked, so they expire after JWTAccessTTL rather than
+	// Tokens.AccessTTL.
+	Auth struct {
+		Mode          string
+		JWTKeys       []string
+		JWTSigningKey string
+		JWTIssuer     string
+		JWTAccessTTL  time.Duration
+	}
+	// TOTP configures two-factor authentication. Issuer names the service in authenticator apps.
+	TOTP struct {
+		Issuer string
+	}
+	// Login configures the throttling of failed logins. After MaxFailures consecutive failures
+	// for an email address, or IPMaxFailures from a client address, logins are refused for
+	// Lockout. Before that, each failure for an email address blocks it for Backoff, doubled
+	// with every failure.
+	Login struct {
+		Enabled       bool
+		MaxFailures   int
+		IPMaxFailures int
+		Backoff       time.Duration
+		Lockout       time.Duration
+	}
+	// AutoMigrate applies the pending migrations when the server starts.
+	AutoMigrate bool
+	// Bells is the bell schedule in the "period=start-end,..." format, see
+	// model.ParseBellSchedule.
+	Bells string
+	// Calendar configures the iCalendar feed: the term the recurring events span and the time
+	// zone of the bell schedule.
+	Calendar struct {
+		TermStart string
+		TermWeeks int
+		Timezone  string
+	}
+	// Cache bounds the in-process cache of token and permission lookups. A Size of 0 disables
+	// the cache.
+	Cache struct {
+		Size int
+		TTL  time.Duration
+	}
+	// Limiter configures rate limiting. Every IP address, and every authenticated user, gets a
+	// token bucket refilled at RPS requests per second that holds up to Burst requests.
+	// GlobalRPS and GlobalBurst bound all clients together.
+	Limiter struct {
+		Enabled     bool
+		RPS         float64
+		Burst       int
+		GlobalRPS   float64
+		GlobalBurst int
+	}
+	// CORS lists the origins that may call the API from a browser.
+	CORS struct {
+		TrustedOrigins []string
+	}
+	// ShutdownDelay is how long the server keeps serving requests after a shutdown signal,
+	// reporting itself as unavailable on /readyz so that load balancers stop sending traffic.
+	ShutdownDelay time.Duration
+	// Mailer selects how emails are delivered: "smtp" sends them through the SMTP server,
+	// "file" appends them to MailerFile and "stdout" prints them, for development.
+	Mailer     string
+	MailerFile string
+	SMTP       struct {
+		Host     string
+		Port     int
+		Username string
+		Password string
+		Sender   string
 	}
 }
 
 type application struct {
-	config config
-	models model.Models
-	logger *jsonlog.Logger
-	wg     sync.WaitGroup
+	config  config
+	models  model.Models
+	logger  *jsonlog.Logger
+	mailer  mailer.Mailer
+	metrics *appMetrics
+	wg      sync.WaitGroup
+	// jwtKeys signs and verifies access tokens in jwt mode, and is nil otherwise.
+	jwtKeys *jwtauth.KeySet
+	// schemaVersion is the version of the newest embedded migration, which readyzHandler
+	// expects the database to be at least at.
+	schemaVersion uint
+	// shuttingDown is set once serve() receives a shutdown signal, so that readyzHandler
+	// reports the instance as unavailable while it drains.
+	shuttingDown atomic.Bool
 }
 
 func main() {
 	var cfg config
-	flag.BoolVar(&cfg.Fill, "fill", false, "Fill Database with some data")
-	flag.IntVar(&cfg.Port, "port", 8080, "API server port")
-	flag.StringVar(&cfg.Env, "env", "development", "Environment (development|staging|production)")
-	flag.StringVar(&cfg.DB.DSN, "db-dsn", "host=db port=5432 user=postgres dbname=schedule password=postgres sslmode=disable", "PostgreSQL DSN")
-	flag.Parse()
+	registerFlags(flag.CommandLine, &cfg)
+	flag.String("config", "", "Path to a config file with one \"flag value\" pair per line")
+	printCfg := flag.Bool("print-config", false, "Print the effective configuration, with secrets redacted, and exit")
 
 	// Init logger
 	logger := jsonlog.NewLogger(os.Stdout, jsonlog.LevelInfo)
 
+	// Every flag can also be set with an APP_ prefixed environment variable or in the config
+	// file. Flags take precedence over environment variables, which take precedence over the
+	// config file, which takes precedence over the defaults.
+	err := ff.Parse(flag.CommandLine, os.Args[1:],
+		ff.WithEnvVarPrefix(envVarPrefix),
+		ff.WithConfigFileFlag("config"),
+		ff.WithConfigFileParser(ff.PlainParser),
+	)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	if *printCfg {
+		printConfig(os.Stdout, flag.CommandLine)
+		return
+	}
+
+	// Check the whole configuration up front, including settings such as the calendar ones that
+	// are only used by some requests, so that a typo is caught at startup rather than on the
+	// first request.
+	err = cfg.validate()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	bells, err := model.ParseBellSchedule(cfg.Bells)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	schemaVersion, err := migrations.Latest()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	sender, err := newMailSender(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	jwtKeys, err := cfg.jwtKeySet()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.PrintError(err, nil)
@@ -59,15 +211,54 @@ func main() {
 		}
 	}()
 
+	models := model.NewModels(db, cfg.DB.QueryTimeout)
+	models.Schedules.Bells = bells
+	models.Schedules.ImportTimeout = cfg.DB.ImportTimeout
+	models.Tokens.SignedAccess = cfg.Auth.Mode == authModeJWT
+	if cfg.Cache.Size > 0 {
+		models.Use(model.NewCache(cfg.Cache.Size, cfg.Cache.TTL))
+	}
+
 	app := &application{
-		config: cfg,
-		models: model.NewModels(db),
-		logger: logger,
+		config:  cfg,
+		models:  models,
+		logger:  logger,
+		mailer:  mailer.New(sender, cfg.SMTP.Sender),
+		metrics: newMetrics(db),
+		jwtKeys: jwtKeys,
+
+		schemaVersion: schemaVersion,
 	}
 
-	if cfg.Fill{
-		err = filler.PopulateDatabase(app.models)
-		if err != nil{
+	// Run a command instead of the server if one was given after the flags.
+	switch flag.Arg(0) {
+	case "":
+	case "create-admin":
+		err = app.createAdminCommand(flag.Args()[1:])
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
+	case "migrate":
+		err = app.migrateCommand(db.DB, flag.Args()[1:])
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
+	default:
+		logger.PrintFatal(fmt.Errorf("unknown command %q", flag.Arg(0)), nil)
+	}
+
+	if cfg.AutoMigrate {
+		err = app.autoMigrate(db.DB)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
+	if cfg.Fill {
+		err = filler.PopulateDatabase(context.Background(), app.models)
+		if err != nil {
 			logger.PrintFatal(err, nil)
 			return
 		}
@@ -78,14 +269,46 @@ func main() {
 	}
 }
 
+// newMailSender returns the mailer.Sender selected by the -mailer flag.
+func newMailSender(cfg config) (mailer.Sender, error) {
+	switch cfg.Mailer {
+	case "smtp":
+		return &mailer.SMTPSender{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			Timeout:  10 * time.Second,
+		}, nil
+	case "file":
+		return mailer.NewFileSender(cfg.MailerFile)
+	case "stdout":
+		return mailer.NewWriterSender(os.Stdout), nil
+	default:
+		return nil, fmt.Errorf("invalid mailer %q, must be smtp, file or stdout", cfg.Mailer)
+	}
+}
+
 func openDB(cfg config) (*sqlx.DB, error) {
 	// Use sql.Open() to create an empty connection pool, using the DSN from the config // struct.
 	db, err := sqlx.Open("postgres", cfg.DB.DSN)
 	if err != nil {
 		return nil, err
 	}
-	err = db.Ping()
+
+	// Size the pool so that a burst of requests can't exhaust the connections PostgreSQL allows,
+	// and close connections that have been idle for a while.
+	db.SetMaxOpenConns(cfg.DB.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.DB.MaxIdleConns)
+	db.SetConnMaxIdleTime(cfg.DB.MaxIdleTime)
+
+	// Fail fast, rather than hang, if the database can't be reached within 5 seconds.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 	return db, nil
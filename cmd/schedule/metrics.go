@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/21b030939/golang-project/pkg/metrics"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+// appMetrics holds the metrics exposed on GET /metrics.
+type appMetrics struct {
+	registry *metrics.Registry
+
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+	inFlight *metrics.GaugeVec
+}
+
+// newMetrics registers the HTTP, database connection pool, runtime and build metrics.
+func newMetrics(db *sqlx.DB) *appMetrics {
+	registry := metrics.NewRegistry()
+
+	m := &appMetrics{
+		registry: registry,
+		requests: registry.NewCounterVec("http_requests_total",
+			"Number of HTTP requests by method, route template and status code.", "method", "route", "code"),
+		duration: registry.NewHistogramVec("http_request_duration_seconds",
+			"Latency of HTTP requests by method and route template.", metrics.DefaultBuckets, "method", "route"),
+		inFlight: registry.NewGaugeVec("http_requests_in_flight",
+			"Number of HTTP requests currently being served."),
+	}
+
+	// Register the in-flight gauge with a zero value, so it's exposed before the first request.
+	m.inFlight.Set(0)
+
+	registry.NewGaugeFunc("db_max_open_connections", "Maximum number of open database connections.", func() float64 {
+		return float64(db.Stats().MaxOpenConnections)
+	})
+	registry.NewGaugeFunc("db_open_connections", "Number of open database connections.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	registry.NewGaugeFunc("db_in_use_connections", "Number of database connections currently in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	registry.NewGaugeFunc("db_idle_connections", "Number of idle database connections.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	registry.NewCounterFunc("db_wait_count_total", "Number of times a database connection was waited for.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	registry.NewCounterFunc("db_wait_duration_seconds_total", "Total time spent waiting for a database connection.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+
+	registry.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+
+	registry.NewGaugeVec("schedule_build_info", "Build information, the value is always 1.", "version", "goversion").
+		Set(1, version, runtime.Version())
+
+	return m
+}
+
+// instrument records the count, latency and status code of every request, labelled with the
+// template of the first route of routers that matches it. The route is resolved up front, rather
+// than by a router middleware, so that responses sent by the middleware wrapping the routers,
+// such as rate limited or unauthenticated requests, are labelled with their route too.
+func (app *application) instrument(routers []*mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		app.metrics.inFlight.Add(1)
+		defer app.metrics.inFlight.Add(-1)
+
+		route := routeTemplate(routers, r)
+
+		mw := &metricsResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(mw, r)
+
+		app.metrics.requests.Inc(r.Method, route, strconv.Itoa(mw.statusCode))
+		app.metrics.duration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
+
+// routeTemplate returns the template of the first route of routers matching the request, for
+// example "/api/v1/schedules/{id:[0-9]+}". Requests that don't match any route, or only with
+// another method, are labelled "unmatched", rather than with their path, to keep the number of
+// series bounded.
+func routeTemplate(routers []*mux.Router, r *http.Request) string {
+	for _, router := range routers {
+		var match mux.RouteMatch
+		if !router.Match(r, &match) || match.MatchErr != nil || match.Route == nil {
+			continue
+		}
+
+		if template, err := match.Route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+
+	return "unmatched"
+}
+
+// metricsResponseWriter records the status code of the response.
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+}
+
+func (mw *metricsResponseWriter) WriteHeader(statusCode int) {
+	if !mw.wroteHeader {
+		mw.statusCode = statusCode
+		mw.wroteHeader = true
+	}
+	mw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
+	mw.wroteHeader = true
+	return mw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying http.ResponseWriter.
+func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRouteTemplate(t *testing.T) {
+	nop := func(w http.ResponseWriter, r *http.Request) {}
+
+	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(nop)
+	router.MethodNotAllowedHandler = http.HandlerFunc(nop)
+
+	api := router.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/schedules", nop).Methods("GET")
+	api.HandleFunc("/schedules/{id:[0-9]+}", nop).Methods("GET", "PUT")
+
+	// The probes router passes every other request on to the main router.
+	probes := mux.NewRouter()
+	probes.NotFoundHandler = router
+	probes.MethodNotAllowedHandler = http.HandlerFunc(nop)
+	probes.HandleFunc("/livez", nop).Methods("GET")
+
+	tests := []struct {
+		method, path string
+		want         string
+	}{
+		{"GET", "/livez", "/livez"},
+		{"GET", "/api/v1/schedules", "/api/v1/schedules"},
+		{"PUT", "/api/v1/schedules/12", "/api/v1/schedules/{id:[0-9]+}"},
+		{"GET", "/api/v1/schedules/abc", "unmatched"},
+		{"DELETE", "/api/v1/schedules/12", "unmatched"},
+		{"POST", "/livez", "unmatched"},
+		{"GET", "/nowhere", "unmatched"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := routeTemplate([]*mux.Router{probes, router}, r); got != tt.want {
+			t.Errorf("%s %s: got %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
@@ -1,19 +1,93 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/21b030939/golang-project/pkg/schedule/model"
 	"github.com/21b030939/golang-project/pkg/schedule/validator"
+	"golang.org/x/time/rate"
 )
 
+// recoverPanic recovers from panics in the handlers and middleware it wraps, and sends a 500
+// Internal Server Error response instead of letting net/http drop the connection. The panic is
+// logged by serverErrorResponse; the stack trace of the log entry includes the panicking code,
+// because the deferred function still runs on top of it.
+func (app *application) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				// http.ErrAbortHandler is used to abort a response on purpose, let net/http
+				// handle it as usual.
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
+				// Setting the "Connection: close" header makes net/http close the
+				// connection once the response has been sent.
+				w.Header().Set("Connection", "close")
+
+				app.serverErrorResponse(w, r, fmt.Errorf("panic: %v", err))
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// enableCORS allows the origins listed in the -cors-trusted-origins flag to call the API from
+// a browser, and answers their preflight requests.
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response depends on the Origin header, and for preflight requests on the
+		// Access-Control-Request-Method header, so caches must not serve it to other origins.
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+
+		if origin != "" {
+			for _, trusted := range app.config.CORS.TrustedOrigins {
+				if origin != trusted {
+					continue
+				}
+
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				// Let the frontend read the Retry-After header of rate limited responses.
+				w.Header().Set("Access-Control-Expose-Headers", "Retry-After")
+
+				// A preflight request is an OPTIONS request with an
+				// Access-Control-Request-Method header. Answer it with the methods and
+				// headers the API accepts, without passing it on to the router.
+				if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+					w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+					w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+					w.Header().Set("Access-Control-Max-Age", "600")
+
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+
+				break
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Authorization" header to the response. This indicates to any caches
 		// that the response may vary based on the value of the Authorization header in the request.
-		w.Header().Set("Vary", "Authorization")
+		// Add rather than set it, so that the Vary headers of enableCORS are kept.
+		w.Header().Add("Vary", "Authorization")
 
 		// Retrieve the value of the Authorization header from teh request. This will return the
 		// empty string "" if there is no such header found.
@@ -29,18 +103,52 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		// Otherwise, we expect the value of the Authorization header to be in the format
-		// "Bearer <token>". We try to split this into its constituent parts, and if the header
-		// isn't in the expected format we return a 401 Unauthorized response using the
-		// invalidAuthenticationTokenResponse helper.
+		// "Bearer <token>" or "ApiKey <key>". We try to split this into its constituent parts,
+		// and if the header isn't in the expected format we return a 401 Unauthorized response
+		// using the invalidAuthenticationTokenResponse helper.
 		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		if len(headerParts) != 2 || (headerParts[0] != "Bearer" && headerParts[0] != "ApiKey") {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
+		// API keys are looked up in the database whatever the auth mode.
+		if headerParts[0] == "ApiKey" {
+			r, ok := app.authenticateAPIKey(w, r, headerParts[1])
+			if !ok {
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Extract the actual authentication toekn from the header parts
 		token := headerParts[1]
 
+		// In jwt mode the token is signed and carries the user's details, so it's verified
+		// without a database lookup.
+		if app.config.Auth.Mode == authModeJWT {
+			claims, user, err := app.verifyAccessToken(token)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetPermissions(r, claims.Permissions)
+			r = app.contextSetToken(r, &model.Token{
+				Plaintext: token,
+				UserID:    user.ID,
+				Expiry:    claims.ExpiresAt.Time,
+				Scope:     model.ScopeAuthentication,
+				FamilyID:  claims.SessionID,
+			})
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Validate the token to make sure it is in a sensible format.
 		v := validator.New()
 
@@ -53,7 +161,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		// Retrieve the details of the user associated with the authentication token.
 		// call invalidAuthenticationTokenResponse if no matching record was found.
-		user, err := app.models.Users.GetForToken(model.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), model.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, model.ErrRecordNotFound):
@@ -65,13 +173,55 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		// Call the contextSetUser healer to add the user information to the request context.
+		// Also keep the token itself, so that handlers can revoke the current session.
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, &model.Token{
+			Plaintext: token,
+			Hash:      model.HashTokenPlaintext(token),
+			UserID:    user.ID,
+			Scope:     model.ScopeAuthentication,
+		})
 
 		// Call next handler in chain
 		next.ServeHTTP(w, r)
 	})
 }
 
+// authenticateCalendarToken authenticates requests carrying a calendar token in the "token" query
+// string parameter. Calendar clients can't send an Authorization header, so subscription URLs
+// embed a ScopeCalendar token instead. Requests without the parameter are passed on unchanged.
+func (app *application) authenticateCalendarToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		v := validator.New()
+
+		if model.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := app.models.Users.GetForToken(r.Context(), model.ScopeCalendar, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+
+		next.ServeHTTP(w, r)
+	}
+}
+
 // requireAuthenticatedUser checks that the user is not anonymous (i.e., they are authenticated).
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -89,6 +239,23 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 	}
 }
 
+// requireUserSession checks that the user logged in themselves, rather than a client acting on
+// their behalf with one of their API keys. Keys are limited to the permission codes they were
+// created with, so they mustn't reach the account, sessions, two-factor authentication or
+// tokens of their owner, which aren't guarded by permission codes.
+func (app *application) requireUserSession(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.apiKeyNotAllowedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireAuthenticatedUser(fn)
+}
+
 // requiredActivatedUser checks that the user is both authenticated and activated.
 func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
 	// Rather than returning this http.HandlerFunc we assign it to the variable fn.
@@ -113,11 +280,16 @@ func (app *application) requirePermissions(code string, next http.HandlerFunc) h
 		// Retrieve the user from the request context.
 		user := app.contextGetUser(r)
 
-		// Get the slice of permission for the user
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
+		// Get the slice of permission for the user, from the access token if it carries them
+		// and from the database otherwise.
+		permissions, ok := app.contextGetPermissions(r)
+		if !ok {
+			var err error
+			permissions, err = app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 		}
 
 		// Check if the slice includes the required permission. If it doesn't, then return a 403
@@ -134,3 +306,121 @@ func (app *application) requirePermissions(code string, next http.HandlerFunc) h
 	// Wrap this with the requireActivatedUser middleware before returning
 	return app.requireActivatedUser(fn)
 }
+
+// rateLimitByIP limits the rate of requests by client IP address, and the rate of requests of
+// all clients together. It runs before authenticate, so that requests with invalid tokens or API
+// keys, which authenticate looks up in the database, are limited too.
+func (app *application) rateLimitByIP(ctx context.Context, next http.Handler) http.Handler {
+	return app.rateLimit(ctx, true, func(r *http.Request) string {
+		return "ip:" + remoteIP(r)
+	}, next)
+}
+
+// rateLimitByUser limits the rate of requests by user ID. It runs after authenticate, so that an
+// authenticated user is limited wherever their requests come from. Anonymous requests are
+// passed on unchanged, rateLimitByIP has already limited them.
+func (app *application) rateLimitByUser(ctx context.Context, next http.Handler) http.Handler {
+	return app.rateLimit(ctx, false, func(r *http.Request) string {
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			return ""
+		}
+
+		return "user:" + strconv.FormatInt(user.ID, 10)
+	}, next)
+}
+
+// rateLimit limits the rate of requests with token buckets: one per client, identified by the
+// key function, and, if global is true, one shared by all clients. Requests for which key returns
+// "" are not limited. Requests over either limit are rejected with 429 Too Many Requests. Idle
+// clients are evicted in a background goroutine, which returns once ctx is done.
+func (app *application) rateLimit(ctx context.Context, global bool, key func(r *http.Request) string, next http.Handler) http.Handler {
+	if !app.config.Limiter.Enabled {
+		return next
+	}
+
+	// client holds the rate limiter of a client and the time it was last seen, so that idle
+	// clients can be evicted.
+	type client struct {
+		limiter  *rate.Limiter
+		lastSeen time.Time
+	}
+
+	// A global rate of 0 or less, or a limiter that isn't global, doesn't limit all clients
+	// together.
+	globalLimit := rate.Limit(app.config.Limiter.GlobalRPS)
+	if globalLimit <= 0 || !global {
+		globalLimit = rate.Inf
+	}
+
+	var (
+		mu      sync.Mutex
+		clients = make(map[string]*client)
+		shared  = rate.NewLimiter(globalLimit, app.config.Limiter.GlobalBurst)
+	)
+
+	// Launch a background goroutine which removes clients that haven't been seen for three
+	// minutes from the map once every minute, until the server shuts down. It's tracked by
+	// app.wg like the other background goroutines.
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			mu.Lock()
+			for key, c := range clients {
+				if time.Since(c.lastSeen) > 3*time.Minute {
+					delete(clients, key)
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := key(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		mu.Lock()
+
+		c, found := clients[key]
+		if !found {
+			c = &client{limiter: rate.NewLimiter(rate.Limit(app.config.Limiter.RPS), app.config.Limiter.Burst)}
+			clients[key] = c
+		}
+		c.lastSeen = time.Now()
+
+		// Reserve a token from both buckets. If either of them would make the request wait,
+		// give both tokens back and tell the client how long to wait instead.
+		now := time.Now()
+		own := c.limiter.ReserveN(now, 1)
+		all := shared.ReserveN(now, 1)
+
+		mu.Unlock()
+
+		delay := max(own.DelayFrom(now), all.DelayFrom(now))
+		if !own.OK() || !all.OK() || delay > 0 {
+			own.CancelAt(now)
+			all.CancelAt(now)
+			if delay == 0 || delay == rate.InfDuration {
+				delay = time.Second
+			}
+			app.rateLimitExceededResponse(w, r, delay)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
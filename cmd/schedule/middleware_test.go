@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/21b030939/golang-project/pkg/jsonlog"
+	"github.com/21b030939/golang-project/pkg/schedule/model"
+)
+
+func TestRateLimit(t *testing.T) {
+	app := &application{}
+	app.config.Limiter.Enabled = true
+	app.config.Limiter.RPS = 1
+	app.config.Limiter.Burst = 2
+	app.config.Limiter.GlobalRPS = 1
+	app.config.Limiter.GlobalBurst = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	byIP := app.rateLimitByIP(ctx, ok)
+	byUser := app.rateLimitByUser(ctx, ok)
+
+	request := func(h http.Handler, ip string, user *model.User) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = ip + ":1234"
+		if user != nil {
+			r = app.contextSetUser(r, user)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Error("429 response without a Retry-After header")
+		}
+		return w.Code
+	}
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		ip      string
+		user    *model.User
+		want    int
+	}{
+		{"first request", byIP, "192.0.2.1", nil, http.StatusOK},
+		{"within the burst", byIP, "192.0.2.1", nil, http.StatusOK},
+		{"over the client burst", byIP, "192.0.2.1", nil, http.StatusTooManyRequests},
+		{"another client", byIP, "192.0.2.2", nil, http.StatusOK},
+		{"over the global burst", byIP, "192.0.2.3", nil, http.StatusTooManyRequests},
+		{"anonymous requests aren't limited by user", byUser, "192.0.2.1", model.AnonymousUser, http.StatusOK},
+		{"user", byUser, "192.0.2.1", &model.User{ID: 1}, http.StatusOK},
+		{"same user from another address", byUser, "192.0.2.2", &model.User{ID: 1}, http.StatusOK},
+		{"same user over the burst", byUser, "192.0.2.3", &model.User{ID: 1}, http.StatusTooManyRequests},
+		{"another user", byUser, "192.0.2.3", &model.User{ID: 2}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		if got := request(tt.handler, tt.ip, tt.user); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// Cancelling the context stops the eviction goroutines.
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("eviction goroutines still running after the context was cancelled")
+	}
+}
+
+func TestRequireUserSession(t *testing.T) {
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelError)}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+	handler := app.requireUserSession(next)
+
+	user := &model.User{ID: 1, Activated: true}
+
+	tests := []struct {
+		name string
+		user *model.User
+		key  *model.APIKey
+		want int
+	}{
+		{"anonymous", model.AnonymousUser, nil, http.StatusUnauthorized},
+		{"logged in", user, nil, http.StatusNoContent},
+		{"api key", user, &model.APIKey{ID: 7, UserID: 1}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/users/me/sessions", nil)
+			r = app.contextSetUser(r, tt.user)
+			if tt.key != nil {
+				r = app.contextSetAPIKey(r, tt.key)
+			}
+
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecoverPanic(t *testing.T) {
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelError)}
+
+	handler := app.recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("something broke")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/schedules", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if got := w.Header().Get("Connection"); got != "close" {
+		t.Errorf("Connection = %q, want close", got)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error == "" || strings.Contains(body.Error, "something broke") {
+		t.Errorf("error = %q, want a generic message", body.Error)
+	}
+}
+
+func TestRecoverPanicAbortHandler(t *testing.T) {
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelError)}
+
+	handler := app.recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler to be re-panicked", err)
+		}
+	}()
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestEnableCORS(t *testing.T) {
+	app := &application{}
+	app.config.CORS.TrustedOrigins = []string{"https://schedule.example.com", "http://localhost:3000"}
+
+	handler := app.enableCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	tests := []struct {
+		name          string
+		method        string
+		origin        string
+		requestMethod string
+		wantCode      int
+		wantOrigin    string
+		wantPreflight bool
+	}{
+		{"no origin", http.MethodGet, "", "", http.StatusTeapot, "", false},
+		{"trusted origin", http.MethodGet, "http://localhost:3000", "", http.StatusTeapot, "http://localhost:3000", false},
+		{"untrusted origin", http.MethodGet, "https://evil.example.com", "", http.StatusTeapot, "", false},
+		{"origin differing by scheme", http.MethodGet, "http://schedule.example.com", "", http.StatusTeapot, "", false},
+		{"preflight", http.MethodOptions, "https://schedule.example.com", http.MethodPut, http.StatusOK, "https://schedule.example.com", true},
+		{"preflight from an untrusted origin", http.MethodOptions, "https://evil.example.com", http.MethodPut, http.StatusTeapot, "", false},
+		{"options without a requested method", http.MethodOptions, "https://schedule.example.com", "", http.StatusTeapot, "https://schedule.example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/v1/schedules", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.requestMethod != "" {
+				r.Header.Set("Access-Control-Request-Method", tt.requestMethod)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Methods") != ""; got != tt.wantPreflight {
+				t.Errorf("Access-Control-Allow-Methods set = %v, want %v", got, tt.wantPreflight)
+			}
+			if vary := w.Header().Values("Vary"); !slices.Contains(vary, "Origin") || !slices.Contains(vary, "Access-Control-Request-Method") {
+				t.Errorf("Vary = %v, want Origin and Access-Control-Request-Method", vary)
+			}
+		})
+	}
+}
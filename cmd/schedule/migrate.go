@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/21b030939/golang-project/pkg/jsonlog"
+	"github.com/21b030939/golang-project/pkg/schedule/migrations"
+	"github.com/golang-migrate/migrate/v4"
+)
+
+// migrateCommand manages the schema of the database with the migrations embedded in the binary.
+// It's run as
+//
+//	schedule [flags] migrate up [N]      apply all pending migrations, or the next N
+//	schedule [flags] migrate down [N]    revert the last migration, or the last N
+//	schedule [flags] migrate version     print the current version
+//	schedule [flags] migrate force V     set the version to V without running any migration,
+//	                                     to recover from a failed migration (-1 for none)
+func (app *application) migrateCommand(db *sql.DB, args []string) error {
+	// Check the arguments before connecting, so that a typo doesn't need a database to report.
+	cmd, err := parseMigrateArgs(args)
+	if err != nil {
+		return err
+	}
+
+	m, err := migrations.New(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	m.Log = migrateLogger{app.logger}
+
+	switch cmd.name {
+	case "up":
+		if cmd.n == 0 {
+			return ignoreNoChange(m.Up())
+		}
+		return ignoreNoChange(m.Steps(cmd.n))
+
+	case "down":
+		return ignoreNoChange(m.Steps(-cmd.n))
+
+	case "version":
+		version, dirty, err := m.Version()
+		switch {
+		case errors.Is(err, migrate.ErrNilVersion):
+			fmt.Println("no migrations applied")
+			return nil
+		case err != nil:
+			return err
+		}
+
+		if dirty {
+			fmt.Printf("%d (dirty)\n", version)
+		} else {
+			fmt.Println(version)
+		}
+		return nil
+
+	default:
+		return m.Force(cmd.n)
+	}
+}
+
+// migrateArgs is a parsed migrate command. For up and down, n is the number of migrations to
+// apply or revert, zero meaning every pending migration for up. For force, n is the version.
+type migrateArgs struct {
+	name string
+	n    int
+}
+
+// parseMigrateArgs checks the arguments of the migrate command, see migrateCommand.
+func parseMigrateArgs(args []string) (migrateArgs, error) {
+	if len(args) == 0 {
+		return migrateArgs{}, errors.New("migrate: expected up, down, version or force")
+	}
+
+	switch args[0] {
+	case "up":
+		n, err := migrateSteps(args[1:], 0)
+		return migrateArgs{name: "up", n: n}, err
+
+	case "down":
+		n, err := migrateSteps(args[1:], 1)
+		return migrateArgs{name: "down", n: n}, err
+
+	case "version":
+		if len(args) != 1 {
+			return migrateArgs{}, errors.New("migrate version: takes no arguments")
+		}
+		return migrateArgs{name: "version"}, nil
+
+	case "force":
+		if len(args) != 2 {
+			return migrateArgs{}, errors.New("migrate force: expected a version")
+		}
+
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version < -1 {
+			return migrateArgs{}, fmt.Errorf("migrate force: invalid version %q", args[1])
+		}
+		return migrateArgs{name: "force", n: version}, nil
+
+	default:
+		return migrateArgs{}, fmt.Errorf("migrate: unknown command %q, expected up, down, version or force", args[0])
+	}
+}
+
+// autoMigrate applies the pending migrations before the server starts, for -auto-migrate.
+func (app *application) autoMigrate(db *sql.DB) error {
+	m, err := migrations.New(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	m.Log = migrateLogger{app.logger}
+
+	return ignoreNoChange(m.Up())
+}
+
+// migrateSteps reads the optional number of migrations to apply or revert.
+func migrateSteps(args []string, def int) (int, error) {
+	switch len(args) {
+	case 0:
+		return def, nil
+	case 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("migrate: invalid number of migrations %q", args[0])
+		}
+		return n, nil
+	default:
+		return 0, errors.New("migrate: too many arguments")
+	}
+}
+
+// ignoreNoChange treats a database that's already at the requested version as a success.
+func ignoreNoChange(err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+	return err
+}
+
+// migrateLogger writes the progress of the migrations, such as the migrations applied, to the
+// application log.
+type migrateLogger struct {
+	logger *jsonlog.Logger
+}
+
+func (l migrateLogger) Printf(format string, v ...interface{}) {
+	l.logger.PrintInfo(strings.TrimSpace(fmt.Sprintf(format, v...)), nil)
+}
+
+func (l migrateLogger) Verbose() bool {
+	return false
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/21b030939/golang-project/pkg/jsonlog"
+)
+
+func TestParseMigrateArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    migrateArgs
+		wantErr string
+	}{
+		{name: "no command", args: nil, wantErr: "migrate: expected up, down, version or force"},
+		{name: "unknown command", args: []string{"sideways"}, wantErr: `migrate: unknown command "sideways", expected up, down, version or force`},
+		{name: "up", args: []string{"up"}, want: migrateArgs{name: "up", n: 0}},
+		{name: "up N", args: []string{"up", "2"}, want: migrateArgs{name: "up", n: 2}},
+		{name: "up zero", args: []string{"up", "0"}, wantErr: `migrate: invalid number of migrations "0"`},
+		{name: "up not a number", args: []string{"up", "all"}, wantErr: `migrate: invalid number of migrations "all"`},
+		{name: "up too many arguments", args: []string{"up", "1", "2"}, wantErr: "migrate: too many arguments"},
+		{name: "down", args: []string{"down"}, want: migrateArgs{name: "down", n: 1}},
+		{name: "down N", args: []string{"down", "3"}, want: migrateArgs{name: "down", n: 3}},
+		{name: "down negative", args: []string{"down", "-1"}, wantErr: `migrate: invalid number of migrations "-1"`},
+		{name: "version", args: []string{"version"}, want: migrateArgs{name: "version"}},
+		{name: "version with an argument", args: []string{"version", "3"}, wantErr: "migrate version: takes no arguments"},
+		{name: "force", args: []string{"force", "15"}, want: migrateArgs{name: "force", n: 15}},
+		{name: "force no version", args: []string{"force", "-1"}, want: migrateArgs{name: "force", n: -1}},
+		{name: "force without a version", args: []string{"force"}, wantErr: "migrate force: expected a version"},
+		{name: "force an invalid version", args: []string{"force", "-2"}, wantErr: `migrate force: invalid version "-2"`},
+		{name: "force not a number", args: []string{"force", "latest"}, wantErr: `migrate force: invalid version "latest"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMigrateArgs(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrateCommandChecksArgsFirst(t *testing.T) {
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelError)}
+
+	// Without a database, reaching the migrations would panic.
+	err := app.migrateCommand(nil, []string{"up", "many"})
+	if err == nil || err.Error() != `migrate: invalid number of migrations "many"` {
+		t.Errorf("err = %v, want the invalid number of migrations error", err)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/21b030939/golang-project/pkg/schedule/model"
+	"github.com/21b030939/golang-project/pkg/schedule/validator"
+	"github.com/gorilla/mux"
+)
+
+// listPermissionsHandler lists every permission code that can be granted.
+func (app *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+}
+
+// listUserPermissionsHandler lists the roles of a user and the permission codes granted to the
+// user, directly or through a role.
+func (app *application) listUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// grantUserPermissionsHandler grants the permission codes in the request body to a user. Codes
+// the user already has are left as they are.
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Codes []string `json:"codes"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	err = app.validatePermissionCodes(r.Context(), v, input.Codes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	err = app.models.Permissions.AddForUser(r.Context(), user.ID, input.Codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// revokeUserPermissionHandler revokes a single permission code granted directly to a user; codes
+// granted through a role stay in effect until the user is removed from the role. Administrators
+// can't revoke permissions:admin from themselves, so that the last administrator can't lock
+// everyone out of the permission API by accident.
+func (app *application) revokeUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	code := mux.Vars(r)["code"]
+
+	v := validator.New()
+
+	// The guard only needs the ID from the URL, so it's checked before anything is looked up.
+	v.Check(!(code == "permissions:admin" && int64(id) == app.contextGetUser(r).ID), "codes",
+		"you can't revoke permissions:admin from yourself")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.validatePermissionCodes(r.Context(), v, []string{code})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	err = app.models.Permissions.RemoveForUser(r.Context(), user.ID, code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// listRolesHandler lists every role along with the permission codes it grants.
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+}
+
+// assignUserRolesHandler assigns a user to the roles in the request body. Roles the user already
+// has are left as they are.
+func (app *application) assignUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	err = app.validateRoles(r.Context(), v, input.Roles)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	err = app.models.Roles.AddForUser(r.Context(), user.ID, input.Roles...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// removeUserRoleHandler removes a user from a single role. For the same reason as in
+// revokeUserPermissionHandler, administrators can't remove themselves from the admin role.
+func (app *application) removeUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	role := mux.Vars(r)["role"]
+
+	v := validator.New()
+
+	v.Check(!(role == model.RoleAdmin && int64(id) == app.contextGetUser(r).ID), "roles",
+		"you can't remove yourself from the admin role")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.validateRoles(r.Context(), v, []string{role})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	err = app.models.Roles.RemoveForUser(r.Context(), user.ID, role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// readUserParam looks up the user identified by the "id" URL parameter. If the user doesn't
+// exist, or the lookup fails, the error response is sent and false is returned.
+func (app *application) readUserParam(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(r.Context(), int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// validatePermissionCodes checks that at least one code was provided and that every code exists.
+func (app *application) validatePermissionCodes(ctx context.Context, v *validator.Validator, codes []string) error {
+	v.Check(len(codes) > 0, "codes", "must contain at least one permission code")
+	if len(codes) == 0 {
+		return nil
+	}
+
+	known, err := app.models.Permissions.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		if !known.Include(code) {
+			v.AddError("codes", "must only contain existing permission codes, see GET /permissions")
+			break
+		}
+	}
+
+	return nil
+}
+
+// validateRoles checks that at least one role was provided and that every role exists.
+func (app *application) validateRoles(ctx context.Context, v *validator.Validator, names []string) error {
+	v.Check(len(names) > 0, "roles", "must contain at least one role")
+	if len(names) == 0 {
+		return nil
+	}
+
+	roles, err := app.models.Roles.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	known := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		known[role.Name] = true
+	}
+
+	for _, name := range names {
+		if !known[name] {
+			v.AddError("roles", "must only contain existing roles, see GET /roles")
+			break
+		}
+	}
+
+	return nil
+}
+
+// writeUserPermissions responds with the roles of the user and the permission codes currently
+// granted to the user, both directly and through those roles.
+func (app *application) writeUserPermissions(w http.ResponseWriter, r *http.Request, user *model.User) {
+	roles, err := app.models.Roles.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = model.Permissions{}
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"user": user, "roles": roles, "permissions": permissions}, nil)
+}
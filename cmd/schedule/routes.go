@@ -1,13 +1,40 @@
 package main
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/gorilla/mux"
 )
 
-// routes is our main application's router.
-func (app *application) routes() http.Handler {
+// routes is our main application's router. Background goroutines started by the middleware
+// return once ctx is done.
+func (app *application) routes(ctx context.Context) http.Handler {
+	r := app.apiRoutes()
+
+	// The probes and the metrics are served by a router of their own, outside of the rate
+	// limiters and authentication, so that orchestrators and scrapers polling them are never
+	// rate limited or asked to authenticate. Every other request is passed on to the main router.
+	probes := mux.NewRouter()
+	probes.MethodNotAllowedHandler = http.HandlerFunc(app.methodNotAllowedResponse)
+	// Liveness and readiness probes
+	probes.HandleFunc("/livez", app.livezHandler).Methods("GET")
+	probes.HandleFunc("/readyz", app.readyzHandler).Methods("GET")
+	// Prometheus metrics
+	probes.Handle("/metrics", app.metrics.registry.Handler()).Methods("GET")
+
+	// Wrap the main router with the rate limit middleware. Requests are limited by IP address
+	// before authentication, so that bad tokens can't be used to flood the database with
+	// lookups, and by user after it, so that a user is limited wherever their requests come from.
+	probes.NotFoundHandler = app.rateLimitByIP(ctx, app.authenticate(app.rateLimitByUser(ctx, r)))
+
+	// Wrap both routers with the panic recovery middleware.
+	return app.instrument([]*mux.Router{probes, r}, app.recoverPanic(app.enableCORS(probes)))
+}
+
+// apiRoutes returns the router of the API itself, which expects requests to be authenticated
+// already.
+func (app *application) apiRoutes() *mux.Router {
 	r := mux.NewRouter()
 	// Convert the app.notFoundResponse helper to a http.Handler using the http.HandlerFunc()
 	// adapter, and then set it as the custom error handler for 404 Not Found responses.
@@ -27,6 +54,14 @@ func (app *application) routes() http.Handler {
 	schedule1.HandleFunc("/schedules", app.getScheduleList).Methods("GET")
 	// Create a new schedule
 	schedule1.HandleFunc("/schedules", app.createScheduleHandler).Methods("POST")
+	// Subscribe to the schedule as an iCalendar feed
+	schedule1.HandleFunc("/schedules.ics", app.authenticateCalendarToken(app.requirePermissions("schedules:read", app.getScheduleCalendar))).Methods("GET")
+	// Import schedules in bulk from a CSV file
+	schedule1.HandleFunc("/schedules/import", app.requirePermissions("schedules:write", app.importSchedulesHandler)).Methods("POST")
+	// Export schedules as a CSV file
+	schedule1.HandleFunc("/schedules/export.csv", app.exportSchedulesHandler).Methods("GET")
+	// Report double-booked cabinets
+	schedule1.HandleFunc("/schedules/conflicts", app.getScheduleConflicts).Methods("GET")
 	// Get a specific schedule
 	schedule1.HandleFunc("/schedules/{id:[0-9]+}", app.getScheduleHandler).Methods("GET")
 	// Update a specific schedule
@@ -34,12 +69,58 @@ func (app *application) routes() http.Handler {
 	// Delete a specific schedule
 	schedule1.HandleFunc("/schedules/{id:[0-9]+}", app.requirePermissions("schedules:write", app.deleteScheduleHandler)).Methods("DELETE")
 
+	disciplines1 := r.PathPrefix("/api/v1").Subrouter()
+
+	// List disciplines
+	disciplines1.HandleFunc("/disciplines", app.getDisciplineList).Methods("GET")
+	// Create a new discipline
+	disciplines1.HandleFunc("/disciplines", app.requirePermissions("disciplines:write", app.createDisciplineHandler)).Methods("POST")
+	// Get a specific discipline
+	disciplines1.HandleFunc("/disciplines/{id:[0-9]+}", app.getDisciplineHandler).Methods("GET")
+	// Update a specific discipline
+	disciplines1.HandleFunc("/disciplines/{id:[0-9]+}", app.requirePermissions("disciplines:write", app.updateDisciplineHandler)).Methods("PUT")
+	// Delete a specific discipline
+	disciplines1.HandleFunc("/disciplines/{id:[0-9]+}", app.requirePermissions("disciplines:write", app.deleteDisciplineHandler)).Methods("DELETE")
+	// List every schedule slot taught for a discipline
+	disciplines1.HandleFunc("/disciplines/{id:[0-9]+}/schedules", app.getDisciplineSchedulesHandler).Methods("GET")
+
 	users1 := r.PathPrefix("/api/v1").Subrouter()
-	// User handlers with Authentication
+	// User handlers with Authentication. The account, sessions, two-factor authentication and
+	// tokens of a user can't be reached with an API key.
 	users1.HandleFunc("/users", app.registerUserHandler).Methods("POST")
 	users1.HandleFunc("/users/activated", app.activateUserHandler).Methods("PUT")
 	users1.HandleFunc("/users/login", app.createAuthenticationTokenHandler).Methods("POST")
+	users1.HandleFunc("/users/password", app.updateUserPasswordHandler).Methods("PUT")
+	users1.HandleFunc("/tokens/password-reset", app.createPasswordResetTokenHandler).Methods("POST")
+	users1.HandleFunc("/tokens/two-factor", app.createTwoFactorSessionHandler).Methods("POST")
+	users1.HandleFunc("/tokens/refresh", app.refreshAuthenticationTokenHandler).Methods("POST")
+	users1.HandleFunc("/tokens/authentication", app.requireUserSession(app.deleteAuthenticationTokenHandler)).Methods("DELETE")
+	users1.HandleFunc("/tokens/authentication/all", app.requireUserSession(app.deleteAllAuthenticationTokensHandler)).Methods("DELETE")
+	users1.HandleFunc("/users/me/sessions", app.requireUserSession(app.listUserSessionsHandler)).Methods("GET")
+	users1.HandleFunc("/users/me/sessions/{id:[0-9]+}", app.requireUserSession(app.deleteUserSessionHandler)).Methods("DELETE")
+	users1.HandleFunc("/users/me/totp", app.requireUserSession(app.requireActivatedUser(app.enrollTOTPHandler))).Methods("POST")
+	users1.HandleFunc("/users/me/totp/confirm", app.requireUserSession(app.requireActivatedUser(app.confirmTOTPHandler))).Methods("POST")
+	users1.HandleFunc("/users/me/totp", app.requireUserSession(app.requireActivatedUser(app.disableTOTPHandler))).Methods("DELETE")
+	users1.HandleFunc("/tokens/calendar", app.requireUserSession(app.requireActivatedUser(app.createCalendarTokenHandler))).Methods("POST")
+	users1.HandleFunc("/users/me/api-keys", app.requireUserSession(app.requireActivatedUser(app.listAPIKeysHandler))).Methods("GET")
+	users1.HandleFunc("/users/me/api-keys", app.requireUserSession(app.requireActivatedUser(app.createAPIKeyHandler))).Methods("POST")
+	users1.HandleFunc("/users/me/api-keys/{id:[0-9]+}", app.requireActivatedUser(app.deleteAPIKeyHandler)).Methods("DELETE")
+
+	permissions1 := r.PathPrefix("/api/v1").Subrouter()
+	// Permission management, restricted to administrators. Permissions and roles can't be
+	// granted or revoked, nor API keys managed, with an API key, so that a leaked key can't be
+	// used to keep access once it's revoked.
+	permissions1.HandleFunc("/permissions", app.requirePermissions("permissions:admin", app.listPermissionsHandler)).Methods("GET")
+	permissions1.HandleFunc("/users/{id:[0-9]+}/permissions", app.requirePermissions("permissions:admin", app.listUserPermissionsHandler)).Methods("GET")
+	permissions1.HandleFunc("/users/{id:[0-9]+}/permissions", app.requireUserSession(app.requirePermissions("permissions:admin", app.grantUserPermissionsHandler))).Methods("POST")
+	permissions1.HandleFunc("/users/{id:[0-9]+}/permissions/{code}", app.requireUserSession(app.requirePermissions("permissions:admin", app.revokeUserPermissionHandler))).Methods("DELETE")
+	permissions1.HandleFunc("/roles", app.requirePermissions("permissions:admin", app.listRolesHandler)).Methods("GET")
+	permissions1.HandleFunc("/users/{id:[0-9]+}/roles", app.requireUserSession(app.requirePermissions("permissions:admin", app.assignUserRolesHandler))).Methods("POST")
+	permissions1.HandleFunc("/users/{id:[0-9]+}/roles/{role}", app.requireUserSession(app.requirePermissions("permissions:admin", app.removeUserRoleHandler))).Methods("DELETE")
+	permissions1.HandleFunc("/users/{id:[0-9]+}/lockout", app.requirePermissions("permissions:admin", app.unlockUserHandler)).Methods("DELETE")
+	permissions1.HandleFunc("/users/{id:[0-9]+}/api-keys", app.requireUserSession(app.requirePermissions("permissions:admin", app.listUserAPIKeysHandler))).Methods("GET")
+	permissions1.HandleFunc("/users/{id:[0-9]+}/api-keys", app.requireUserSession(app.requirePermissions("permissions:admin", app.createUserAPIKeyHandler))).Methods("POST")
+	permissions1.HandleFunc("/users/{id:[0-9]+}/api-keys/{key_id:[0-9]+}", app.requireUserSession(app.requirePermissions("permissions:admin", app.deleteUserAPIKeyHandler))).Methods("DELETE")
 
-	// Wrap the router with the panic recovery middleware and rate limit middleware.
-	return app.authenticate(r)
+	return r
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/21b030939/golang-project/pkg/jsonlog"
+	"github.com/21b030939/golang-project/pkg/schedule/model"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestProbesAreNotRateLimited(t *testing.T) {
+	// Opening the pool doesn't connect to the database, the metrics only read its stats.
+	db, err := sqlx.Open("postgres", "postgres://localhost/schedule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelError), metrics: newMetrics(db)}
+	app.config.Limiter.Enabled = true
+	app.config.Limiter.RPS = 1
+	app.config.Limiter.Burst = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler := app.routes(ctx)
+
+	get := func(path string, header http.Header) int {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for key, values := range header {
+			r.Header[key] = values
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// A malformed Authorization header is rejected by authenticate, which the probes skip.
+	badAuth := http.Header{"Authorization": {"Basic abc"}}
+
+	for i := 0; i < 3; i++ {
+		for _, path := range []string{"/livez", "/metrics"} {
+			if code := get(path, badAuth); code != http.StatusOK {
+				t.Fatalf("GET %s: status = %d, want %d", path, code, http.StatusOK)
+			}
+		}
+	}
+
+	if code := get("/api/v1/nowhere", nil); code != http.StatusNotFound {
+		t.Errorf("first API request: status = %d, want %d", code, http.StatusNotFound)
+	}
+	if code := get("/api/v1/nowhere", nil); code != http.StatusTooManyRequests {
+		t.Errorf("second API request: status = %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestAPIKeysCantManageAccess(t *testing.T) {
+	app := &application{logger: jsonlog.NewLogger(io.Discard, jsonlog.LevelError)}
+	router := app.apiRoutes()
+
+	user := &model.User{ID: 1, Activated: true}
+	key := &model.APIKey{ID: 7, UserID: 1, Permissions: model.Permissions{"permissions:admin"}}
+
+	tests := []struct {
+		method, path string
+	}{
+		{http.MethodPost, "/api/v1/users/2/permissions"},
+		{http.MethodDelete, "/api/v1/users/2/permissions/schedules:write"},
+		{http.MethodPost, "/api/v1/users/2/roles"},
+		{http.MethodDelete, "/api/v1/users/2/roles/admin"},
+		{http.MethodGet, "/api/v1/users/2/api-keys"},
+		{http.MethodPost, "/api/v1/users/2/api-keys"},
+		{http.MethodDelete, "/api/v1/users/2/api-keys/3"},
+		{http.MethodGet, "/api/v1/users/me/api-keys"},
+		{http.MethodPost, "/api/v1/users/me/api-keys"},
+		{http.MethodDelete, "/api/v1/users/me/api-keys/8"},
+		{http.MethodGet, "/api/v1/users/me/sessions"},
+		{http.MethodDelete, "/api/v1/tokens/authentication/all"},
+		{http.MethodPost, "/api/v1/users/me/totp"},
+		{http.MethodPost, "/api/v1/tokens/calendar"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r = app.contextSetUser(r, user)
+			r = app.contextSetAPIKey(r, key)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "can't be accessed with an API key") {
+				t.Errorf("status = %d, body = %s, want the API key not allowed response", w.Code, w.Body)
+			}
+		})
+	}
+}
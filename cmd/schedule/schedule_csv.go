@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/21b030939/golang-project/pkg/schedule/model"
+	"github.com/21b030939/golang-project/pkg/schedule/validator"
+)
+
+// scheduleCSVHeader lists the columns of the schedule CSV export. The import accepts the same
+// columns, ignoring the ones that are computed by the server (id, discipline, startTime and
+// endTime), so an export can be edited in a spreadsheet and imported again.
+var scheduleCSVHeader = []string{
+	"id", "disciplineId", "discipline", "cabinet", "dayOfWeek", "weekParity", "timePeriod",
+	"startTime", "endTime",
+}
+
+// scheduleCSVRequired lists the columns that every import must contain.
+var scheduleCSVRequired = []string{"disciplineId", "cabinet", "dayOfWeek", "timePeriod"}
+
+// scheduleImportRow reports the problems found in a single row of an imported CSV file.
+// Conflicts holds the ids of existing schedules the row double-books, and ConflictingLines the
+// lines of the same file it double-books.
+type scheduleImportRow struct {
+	Line             int               `json:"line"`
+	Errors           map[string]string `json:"errors,omitempty"`
+	Conflicts        []int64           `json:"conflicts,omitempty"`
+	ConflictingLines []int             `json:"conflictingLines,omitempty"`
+}
+
+// importSchedulesHandler creates schedules in bulk from a CSV file, sent either as the "file"
+// field of a multipart form or as a text/csv request body. The import is all-or-nothing: if any
+// row is invalid or double-books a cabinet, nothing is inserted and the response lists the
+// problems of every affected row.
+func (app *application) importSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	// Limit the size of the upload to 10MB, which is plenty for a term's worth of schedules.
+	maxBytes := int64(10 << 20)
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	var body io.Reader
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			app.badRequestResponse(w, r, fmt.Errorf("multipart form must contain a CSV file in the \"file\" field"))
+			return
+		}
+		defer file.Close()
+		body = file
+	case "text/csv", "application/csv":
+		body = r.Body
+	default:
+		app.errorResponse(w, r, http.StatusUnsupportedMediaType, "the request must be multipart/form-data or text/csv")
+		return
+	}
+
+	schedules, lines, rows, err := app.readScheduleCSV(r.Context(), body)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if len(rows) > 0 {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, envelope{
+			"message": "the import contains invalid rows, nothing was imported",
+			"rows":    rows,
+		})
+		return
+	}
+
+	err = app.models.Schedules.InsertMany(r.Context(), schedules)
+	if err != nil {
+		var importErr *model.ScheduleImportError
+		switch {
+		case errors.As(err, &importErr):
+			// Rows of the file that were inserted before the transaction was rolled back
+			// still carry their (now unused) ids. Report conflicts with them by line
+			// instead, since those ids never became visible to anyone.
+			imported := make(map[int64]int)
+			for i, schedule := range schedules {
+				if id, err := strconv.ParseInt(schedule.Id, 10, 64); err == nil {
+					imported[id] = lines[i]
+				}
+			}
+
+			for i, conflict := range importErr.Conflicts {
+				row := scheduleImportRow{Line: lines[i]}
+				for _, id := range conflict.ScheduleIds {
+					if line, ok := imported[id]; ok {
+						row.ConflictingLines = append(row.ConflictingLines, line)
+					} else {
+						row.Conflicts = append(row.Conflicts, id)
+					}
+				}
+				rows = append(rows, row)
+			}
+			sortImportRows(rows)
+			app.errorResponse(w, r, http.StatusConflict, envelope{
+				"message": "the import double-books cabinets, nothing was imported",
+				"rows":    rows,
+			})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, envelope{"imported": len(schedules), "schedules": schedules}, nil)
+}
+
+// readScheduleCSV parses and validates the CSV file, and looks up the discipline of every
+// schedule. It returns the schedules along with the line of the file each one was read from.
+// Rows that fail validation are reported in the returned scheduleImportRow slice; an error is
+// returned only if the file can't be read as CSV at all.
+func (app *application) readScheduleCSV(ctx context.Context, body io.Reader) ([]*model.Schedule, []int, []scheduleImportRow, error) {
+	parsed, parsedLines, rows, err := parseScheduleCSV(body, app.models.Schedules.Bells)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var (
+		schedules   []*model.Schedule
+		lines       []int
+		disciplines = make(map[int64]*model.Discipline)
+	)
+
+	for i, schedule := range parsed {
+		// Most imports reference a handful of disciplines many times, so only look each of
+		// them up once.
+		if discipline, ok := disciplines[schedule.DisciplineId]; ok {
+			schedule.Discipline = discipline.Name
+		} else {
+			v := validator.New()
+
+			err = app.resolveScheduleDiscipline(ctx, v, schedule)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+
+			if !v.Valid() {
+				rows = append(rows, scheduleImportRow{Line: parsedLines[i], Errors: v.Errors})
+				continue
+			}
+			disciplines[schedule.DisciplineId] = schedule.DisciplineDetails
+		}
+		schedule.DisciplineDetails = nil
+
+		schedules = append(schedules, schedule)
+		lines = append(lines, parsedLines[i])
+	}
+
+	sortImportRows(rows)
+
+	return schedules, lines, rows, nil
+}
+
+// parseScheduleCSV parses the CSV file and validates every row on its own, without looking
+// anything up in the database. It returns the valid schedules along with the line of the file
+// each one was read from, and the problems of the invalid rows.
+func parseScheduleCSV(body io.Reader, bells model.BellSchedule) ([]*model.Schedule, []int, []scheduleImportRow, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil, nil, errors.New("CSV file must not be empty")
+		}
+		return nil, nil, nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range scheduleCSVRequired {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, nil, fmt.Errorf("CSV header must contain the %q column", name)
+		}
+	}
+
+	// Every row must have as many fields as the header.
+	reader.FieldsPerRecord = len(header)
+
+	var (
+		schedules []*model.Schedule
+		lines     []int
+		rows      []scheduleImportRow
+	)
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		v := validator.New()
+
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		number := func(name string) int {
+			n, err := strconv.Atoi(field(name))
+			if err != nil {
+				v.AddError(name, "must be an integer value")
+			}
+			return n
+		}
+
+		schedule := &model.Schedule{
+			DisciplineId: int64(number("disciplineId")),
+			Cabinet:      field("cabinet"),
+			TimePeriod:   number("timePeriod"),
+			DayOfWeek:    number("dayOfWeek"),
+			WeekParity:   field("weekParity"),
+		}
+
+		if model.ValidateSchedule(v, schedule, bells); !v.Valid() {
+			rows = append(rows, scheduleImportRow{Line: line, Errors: v.Errors})
+			continue
+		}
+
+		schedules = append(schedules, schedule)
+		lines = append(lines, line)
+	}
+
+	if len(schedules) == 0 && len(rows) == 0 {
+		return nil, nil, nil, errors.New("CSV file must contain at least one schedule")
+	}
+
+	return schedules, lines, rows, nil
+}
+
+// exportSchedulesHandler streams every schedule matching the same filters as getScheduleList as
+// a CSV file.
+func (app *application) exportSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	q := app.readScheduleQuery(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="schedules.csv"`)
+
+	cw := csv.NewWriter(w)
+
+	// Once the first row has been written the status code can't be changed any more, so errors
+	// from here on can only be logged.
+	err := cw.Write(scheduleCSVHeader)
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	err = app.forEachSchedule(r.Context(), q, func(schedule *model.Schedule) error {
+		record := []string{
+			schedule.Id,
+			strconv.FormatInt(schedule.DisciplineId, 10),
+			schedule.Discipline,
+			schedule.Cabinet,
+			strconv.Itoa(schedule.DayOfWeek),
+			schedule.WeekParity,
+			strconv.Itoa(schedule.TimePeriod),
+			schedule.StartTime,
+			schedule.EndTime,
+		}
+		return cw.Write(record)
+	})
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		app.logError(r, err)
+	}
+}
+
+// sortImportRows orders the import report by line number.
+func sortImportRows(rows []scheduleImportRow) {
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Line < rows[j].Line
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/21b030939/golang-project/pkg/schedule/model"
+)
+
+func TestParseScheduleCSV(t *testing.T) {
+	bells, err := model.ParseBellSchedule(model.DefaultBellSchedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		input     string
+		wantErr   bool
+		wantLines []int
+		// wantRows maps the line of every invalid row to the fields reported for it.
+		wantRows map[int][]string
+	}{
+		{
+			name:      "minimal columns",
+			input:     "disciplineId,cabinet,dayOfWeek,timePeriod\n1,101,1,1\n2,102,5,8\n",
+			wantLines: []int{2, 3},
+		},
+		{
+			name: "export format",
+			input: "id,disciplineId,discipline,cabinet,dayOfWeek,weekParity,timePeriod,startTime,endTime\n" +
+				"7,1,Algorithms,101,2,odd,3,10:00,10:50\n",
+			wantLines: []int{2},
+		},
+		{
+			name:      "columns in any order with spaces",
+			input:     "timePeriod, cabinet, disciplineId, dayOfWeek\n 2, 101, 1, 3\n",
+			wantLines: []int{2},
+		},
+		{
+			name: "invalid rows",
+			input: "disciplineId,cabinet,dayOfWeek,timePeriod,weekParity\n" +
+				"1,101,1,1,\n" +
+				"x,101,1,1,\n" +
+				"1,101,8,9,\n" +
+				"1,101,1,1,weekly\n",
+			wantLines: []int{2},
+			wantRows: map[int][]string{
+				3: {"disciplineId"},
+				4: {"dayOfWeek", "timePeriod"},
+				5: {"weekParity"},
+			},
+		},
+		{name: "empty file", input: "", wantErr: true},
+		{name: "header only", input: "disciplineId,cabinet,dayOfWeek,timePeriod\n", wantErr: true},
+		{name: "missing column", input: "disciplineId,cabinet,dayOfWeek\n1,101,1\n", wantErr: true},
+		{name: "wrong number of fields", input: "disciplineId,cabinet,dayOfWeek,timePeriod\n1,101,1\n", wantErr: true},
+		{name: "malformed quotes", input: "disciplineId,cabinet,dayOfWeek,timePeriod\n1,\"101,1,1\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedules, lines, rows, err := parseScheduleCSV(strings.NewReader(tt.input), bells)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(schedules) != len(lines) {
+				t.Fatalf("got %d schedules for %d lines", len(schedules), len(lines))
+			}
+			if !slices.Equal(lines, tt.wantLines) {
+				t.Errorf("lines = %v, want %v", lines, tt.wantLines)
+			}
+
+			if len(rows) != len(tt.wantRows) {
+				t.Fatalf("got %d invalid rows, want %d: %+v", len(rows), len(tt.wantRows), rows)
+			}
+			for _, row := range rows {
+				fields, ok := tt.wantRows[row.Line]
+				if !ok {
+					t.Errorf("unexpected invalid row at line %d: %v", row.Line, row.Errors)
+					continue
+				}
+				if len(row.Errors) != len(fields) {
+					t.Errorf("line %d: errors = %v, want errors for %v", row.Line, row.Errors, fields)
+				}
+				for _, field := range fields {
+					if _, ok := row.Errors[field]; !ok {
+						t.Errorf("line %d: no error for %q in %v", row.Line, field, row.Errors)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParseScheduleCSVValues(t *testing.T) {
+	bells, err := model.ParseBellSchedule(model.DefaultBellSchedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "disciplineId,cabinet,dayOfWeek,weekParity,timePeriod,discipline\n12, 305A ,4,even,6,ignored\n"
+
+	schedules, _, _, err := parseScheduleCSV(strings.NewReader(input), bells)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := model.Schedule{DisciplineId: 12, Cabinet: "305A", DayOfWeek: 4, WeekParity: "even", TimePeriod: 6}
+	got := *schedules[0]
+	if got.DisciplineId != want.DisciplineId || got.Cabinet != want.Cabinet || got.DayOfWeek != want.DayOfWeek ||
+		got.WeekParity != want.WeekParity || got.TimePeriod != want.TimePeriod || got.Discipline != "" {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net/http"
+	"net/url"
 
 	"github.com/21b030939/golang-project/pkg/schedule/model"
 	"github.com/21b030939/golang-project/pkg/schedule/validator"
@@ -11,9 +13,11 @@ import (
 
 func (app *application) createScheduleHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Discipline string `json:"discipline"`
-		Cabinet    string `json:"cabinet"`
-		TimePeriod int    `json:"timePeriod"`
+		DisciplineId int64  `json:"disciplineId"`
+		Cabinet      string `json:"cabinet"`
+		TimePeriod   int    `json:"timePeriod"`
+		DayOfWeek    int    `json:"dayOfWeek"`
+		WeekParity   string `json:"weekParity"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -24,36 +28,104 @@ func (app *application) createScheduleHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	schedule := &model.Schedule{
-		Discipline: input.Discipline,
-		Cabinet:    input.Cabinet,
-		TimePeriod: input.TimePeriod,
+		DisciplineId: input.DisciplineId,
+		Cabinet:      input.Cabinet,
+		TimePeriod:   input.TimePeriod,
+		DayOfWeek:    input.DayOfWeek,
+		WeekParity:   input.WeekParity,
 	}
 
-	err = app.models.Schedules.Insert(schedule)
+	v := validator.New()
+
+	if model.ValidateSchedule(v, schedule, app.models.Schedules.Bells); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.resolveScheduleDiscipline(r.Context(), v, schedule)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Schedules.Insert(r.Context(), schedule)
+	if err != nil {
+		var conflictErr *model.ScheduleConflictError
+		switch {
+		case errors.As(err, &conflictErr):
+			app.scheduleConflictResponse(w, r, conflictErr.ScheduleIds)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	app.writeJSON(w, http.StatusCreated, envelope{"schedule": schedule}, nil)
 }
 
+// scheduleQuery holds the filters shared by every endpoint that lists schedules.
+type scheduleQuery struct {
+	Discipline          string
+	DayOfWeek           int
+	TimePeriodValueFrom int
+	TimePeriodValueTo   int
+}
+
+// readScheduleQuery extracts the schedule filters from the URL query string. Problems with the
+// values are recorded in the provided Validator instance.
+func (app *application) readScheduleQuery(qs url.Values, v *validator.Validator) scheduleQuery {
+	var q scheduleQuery
+
+	// Use our helpers to extract the discipline, day and time period range query string values,
+	// falling back to the zero values (i.e. no filtering) if they are not provided by the client.
+	q.Discipline = app.readStrings(qs, "discipline", "")
+	q.DayOfWeek = app.readInt(qs, "day", 0, v)
+	q.TimePeriodValueFrom = app.readInt(qs, "timePeriodFrom", 0, v)
+	q.TimePeriodValueTo = app.readInt(qs, "timePeriodTo", 0, v)
+
+	v.Check(q.DayOfWeek >= 0 && q.DayOfWeek <= 7, "day", "must be between 1 (Monday) and 7 (Sunday)")
+
+	return q
+}
+
+// forEachSchedule calls fn for every schedule matching the query, in id order. It fetches the
+// schedules one page at a time, so that large exports don't have to be held in memory at once.
+func (app *application) forEachSchedule(ctx context.Context, q scheduleQuery, fn func(*model.Schedule) error) error {
+	filters := model.Filters{Page: 1, PageSize: 100, Sort: "id", SortSafeList: []string{"id"}}
+
+	for {
+		schedules, metadata, err := app.models.Schedules.GetAll(ctx, q.Discipline, 0, q.DayOfWeek, q.TimePeriodValueFrom, q.TimePeriodValueTo, filters)
+		if err != nil {
+			return err
+		}
+
+		for _, schedule := range schedules {
+			if err := fn(schedule); err != nil {
+				return err
+			}
+		}
+
+		if filters.Page >= metadata.LastPage {
+			return nil
+		}
+		filters.Page++
+	}
+}
+
 func (app *application) getScheduleList(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Discipline          string
-		TimePeriodValueFrom int
-		TimePeriodValueTo   int
+		scheduleQuery
 		model.Filters
 	}
 	v := validator.New()
 	qs := r.URL.Query()
 
-	// Use our helpers to extract the title and nutrition value range query string values, falling back to the
-	// defaults of an empty string and an empty slice, respectively, if they are not provided
-	// by the client.
-	input.Discipline = app.readStrings(qs, "discipline", "")
-	input.TimePeriodValueFrom = app.readInt(qs, "timePeriodFrom", 0, v)
-	input.TimePeriodValueTo = app.readInt(qs, "timePeriodTo", 0, v)
+	input.scheduleQuery = app.readScheduleQuery(qs, v)
 
 	// Ge the page and page_size query string value as integers. Notice that we set the default
 	// page value to 1 and default page_size to 20, and that we pass the validator instance
@@ -69,16 +141,16 @@ func (app *application) getScheduleList(w http.ResponseWriter, r *http.Request)
 	// name of the column in the database.
 	input.Filters.SortSafeList = []string{
 		// ascending sort values
-		"id", "discipline", "time_period",
+		"id", "discipline", "day_of_week", "time_period",
 		// descending sort values
-		"-id", "-discipline", "-time_period",
+		"-id", "-discipline", "-day_of_week", "-time_period",
 	}
 
 	if model.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	schedules, metadata, err := app.models.Schedules.GetAll(input.Discipline, input.TimePeriodValueFrom, input.TimePeriodValueTo, input.Filters)
+	schedules, metadata, err := app.models.Schedules.GetAll(r.Context(), input.Discipline, 0, input.DayOfWeek, input.TimePeriodValueFrom, input.TimePeriodValueTo, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -94,7 +166,7 @@ func (app *application) getScheduleHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	schedule, err := app.models.Schedules.Get(id)
+	schedule, err := app.models.Schedules.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrRecordNotFound):
@@ -115,7 +187,7 @@ func (app *application) updateScheduleHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	schedule, err := app.models.Schedules.Get(id)
+	schedule, err := app.models.Schedules.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrRecordNotFound):
@@ -127,9 +199,11 @@ func (app *application) updateScheduleHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	var input struct {
-		Discipline *string `json:"discipline"`
-		Cabinet    *string `json:"cabinet"`
-		TimePeriod *int    `json:"timePeriod"`
+		DisciplineId *int64  `json:"disciplineId"`
+		Cabinet      *string `json:"cabinet"`
+		TimePeriod   *int    `json:"timePeriod"`
+		DayOfWeek    *int    `json:"dayOfWeek"`
+		WeekParity   *string `json:"weekParity"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -138,8 +212,8 @@ func (app *application) updateScheduleHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	if input.Discipline != nil {
-		schedule.Discipline = *input.Discipline
+	if input.DisciplineId != nil {
+		schedule.DisciplineId = *input.DisciplineId
 	}
 
 	if input.Cabinet != nil {
@@ -150,18 +224,42 @@ func (app *application) updateScheduleHandler(w http.ResponseWriter, r *http.Req
 		schedule.TimePeriod = *input.TimePeriod
 	}
 
+	if input.DayOfWeek != nil {
+		schedule.DayOfWeek = *input.DayOfWeek
+	}
+
+	if input.WeekParity != nil {
+		schedule.WeekParity = *input.WeekParity
+	}
+
 	v := validator.New()
 
-	if model.ValidateSchedule(v, schedule); !v.Valid() {
+	if model.ValidateSchedule(v, schedule, app.models.Schedules.Bells); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.resolveScheduleDiscipline(r.Context(), v, schedule)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Schedules.Update(schedule)
+	err = app.models.Schedules.Update(r.Context(), schedule)
 	if err != nil {
+		var conflictErr *model.ScheduleConflictError
 		switch {
 		case errors.Is(err, model.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.As(err, &conflictErr):
+			app.scheduleConflictResponse(w, r, conflictErr.ScheduleIds)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -178,7 +276,7 @@ func (app *application) deleteScheduleHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = app.models.Schedules.Delete(id)
+	err = app.models.Schedules.Delete(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrRecordNotFound):
@@ -190,4 +288,36 @@ func (app *application) deleteScheduleHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	app.writeJSON(w, http.StatusOK, envelope{"message": "success"}, nil)
-}
\ No newline at end of file
+}
+
+// getScheduleConflicts reports every cabinet that is currently booked more than once for the same
+// time period.
+func (app *application) getScheduleConflicts(w http.ResponseWriter, r *http.Request) {
+	conflicts, err := app.models.Schedules.Conflicts(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"conflicts": conflicts}, nil)
+}
+
+// resolveScheduleDiscipline looks up the discipline referenced by schedule.DisciplineId and
+// copies its details onto the schedule. If the discipline doesn't exist, an error is recorded in
+// the validator instead; only unexpected database errors are returned.
+func (app *application) resolveScheduleDiscipline(ctx context.Context, v *validator.Validator, schedule *model.Schedule) error {
+	discipline, err := app.models.Disciplines.Get(ctx, int(schedule.DisciplineId))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("disciplineId", "must reference an existing discipline")
+			return nil
+		default:
+			return err
+		}
+	}
+
+	schedule.Discipline = discipline.Name
+	schedule.DisciplineDetails = discipline
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestScheduleDisciplineLink(t *testing.T) {
+	serve := newTestRouter(t, "schedules:write")
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{"create with a discipline name", http.MethodPost, "/api/v1/schedules", `{"discipline": "Calculus II", "cabinet": "256", "timePeriod": 3, "dayOfWeek": 1}`, http.StatusBadRequest, ""},
+		{"create without a discipline", http.MethodPost, "/api/v1/schedules", `{"cabinet": "256", "timePeriod": 3, "dayOfWeek": 1}`, http.StatusUnprocessableEntity, "must be provided"},
+		{"create with a discipline", http.MethodPost, "/api/v1/schedules", `{"disciplineId": 4, "cabinet": "256", "timePeriod": 3, "dayOfWeek": 1}`, http.StatusInternalServerError, ""},
+		{"schedules of an invalid discipline", http.MethodGet, "/api/v1/disciplines/0/schedules", "", http.StatusNotFound, ""},
+		{"schedules of a discipline", http.MethodGet, "/api/v1/disciplines/4/schedules", "", http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.method, tt.path, tt.body)
+
+			if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("status = %d, body = %s, want %d containing %q", w.Code, w.Body, tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+}
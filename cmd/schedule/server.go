@@ -13,10 +13,15 @@ import (
 )
 
 func (app *application) serve() error {
+	// background is cancelled once the server has shut down, which stops the background
+	// goroutines of the middleware.
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
 	// Declare an HTTP server using the same settings as in our main() function.
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.Port),
-		Handler:      app.routes(),
+		Handler:      app.routes(background),
 		ErrorLog:     log.New(app.logger, "", 0),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
@@ -48,6 +53,17 @@ func (app *application) serve() error {
 			"signal": s.String(),
 		})
 
+		// Report the instance as unavailable on /readyz straight away, and keep serving for
+		// the shutdown delay, so that load balancers notice and stop sending new requests
+		// before the server stops accepting them.
+		app.shuttingDown.Store(true)
+		if app.config.ShutdownDelay > 0 {
+			app.logger.PrintInfo("draining", map[string]string{
+				"delay": app.config.ShutdownDelay.String(),
+			})
+			time.Sleep(app.config.ShutdownDelay)
+		}
+
 		// Create a context with a 5-second timeout.
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -65,9 +81,11 @@ func (app *application) serve() error {
 			"addr": srv.Addr,
 		})
 
-		// Call Wait() to block until our WaitGroup counter is zero. This essentially blocks
-		// until the background goroutines have finished. Then we return nil on the shutdownError
-		// channel to indicate that the shutdown as compleeted without any issues.
+		// Stop the background goroutines of the middleware, and call Wait() to block until our
+		// WaitGroup counter is zero. This essentially blocks until the background goroutines
+		// have finished. Then we return nil on the shutdownError channel to indicate that the
+		// shutdown as compleeted without any issues.
+		stopBackground()
 		app.wg.Wait()
 		shutdownError <- nil
 
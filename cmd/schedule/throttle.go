@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/21b030939/golang-project/pkg/schedule/model"
+)
+
+// accountThrottlePolicy applies to the failed logins for an email address: a delay that doubles
+// with every failure, then a lockout.
+func (app *application) accountThrottlePolicy() model.ThrottlePolicy {
+	return model.ThrottlePolicy{
+		MaxFailures: app.config.Login.MaxFailures,
+		Backoff:     app.config.Login.Backoff,
+		Lockout:     app.config.Login.Lockout,
+	}
+}
+
+// ipThrottlePolicy applies to the failed logins from a client IP address. Many users may share an
+// address, behind the campus NAT for instance, so there is no delay, only a lockout after more
+// failures than for a single account.
+func (app *application) ipThrottlePolicy() model.ThrottlePolicy {
+	return model.ThrottlePolicy{
+		MaxFailures: app.config.Login.IPMaxFailures,
+		Lockout:     app.config.Login.Lockout,
+	}
+}
+
+// loginAttempt is a login attempt reserved against the throttles of an email address and of the
+// client address. Both are nil when login throttling is turned off.
+type loginAttempt struct {
+	email, ip *model.LoginThrottle
+}
+
+// reserveLoginAttempt counts a login attempt as failed for the email address and the client
+// before the password is checked, so that concurrent guesses can't all be checked before the
+// first failure is recorded. If either is blocked after failed logins, the attempt is refused
+// with a 429 Too Many Requests response and false is returned.
+func (app *application) reserveLoginAttempt(w http.ResponseWriter, r *http.Request, email string) (*loginAttempt, bool) {
+	attempt := &loginAttempt{}
+
+	if !app.config.Login.Enabled {
+		return attempt, true
+	}
+
+	var err error
+
+	attempt.email, err = app.models.LoginThrottles.Reserve(r.Context(), model.EmailThrottleKey(email), app.accountThrottlePolicy())
+	if err != nil {
+		app.loginThrottleErrorResponse(w, r, attempt.email, err)
+		return nil, false
+	}
+
+	attempt.ip, err = app.models.LoginThrottles.Reserve(r.Context(), model.IPThrottleKey(remoteIP(r)), app.ipThrottlePolicy())
+	if err != nil {
+		// The attempt isn't made, so it mustn't count against the email address either.
+		releaseErr := app.models.LoginThrottles.Release(r.Context(), attempt.email.Key)
+		if releaseErr != nil {
+			app.logger.PrintError(releaseErr, map[string]string{"key": attempt.email.Key})
+		}
+
+		app.loginThrottleErrorResponse(w, r, attempt.ip, err)
+		return nil, false
+	}
+
+	return attempt, true
+}
+
+// loginThrottleErrorResponse sends the response for an error returned by
+// LoginThrottleModel.Reserve.
+func (app *application) loginThrottleErrorResponse(w http.ResponseWriter, r *http.Request, throttle *model.LoginThrottle, err error) {
+	switch {
+	case errors.Is(err, model.ErrLoginThrottled):
+		app.loginThrottledResponse(w, r, time.Until(throttle.BlockedUntil))
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// failedLoginResponse sends the invalid credentials response for a failed attempt, which was
+// already counted when it was reserved. The email address and client reaching their lockout
+// threshold with it are logged.
+func (app *application) failedLoginResponse(w http.ResponseWriter, r *http.Request, attempt *loginAttempt) {
+	for _, t := range []struct {
+		throttle *model.LoginThrottle
+		policy   model.ThrottlePolicy
+	}{
+		{attempt.email, app.accountThrottlePolicy()},
+		{attempt.ip, app.ipThrottlePolicy()},
+	} {
+		// Only log the failure that triggered the lockout, not every one after it.
+		if t.throttle != nil && t.throttle.Failures == t.policy.MaxFailures {
+			app.logger.PrintInfo("login locked out", map[string]string{
+				"key":      t.throttle.Key,
+				"failures": strconv.Itoa(t.throttle.Failures),
+				"until":    t.throttle.BlockedUntil.UTC().Format(time.RFC3339),
+			})
+		}
+	}
+
+	app.invalidCredentialsResponse(w, r)
+}
+
+// acceptPassword gives back the attempt reserved for the client once the password was entered
+// correctly. The attempt reserved for the email address is kept until the login is complete,
+// which for users with two-factor authentication is once their second factor is accepted: a
+// wrong code counts as a failed login for the account.
+func (app *application) acceptPassword(ctx context.Context, attempt *loginAttempt) error {
+	if attempt.ip == nil {
+		return nil
+	}
+
+	return app.models.LoginThrottles.Release(ctx, attempt.ip.Key)
+}
+
+// resetLoginThrottle forgets the failed logins for an email address once the user logged in.
+// The failures of the client address are kept, otherwise an attacker could clear them by
+// logging into an account of their own between guesses.
+func (app *application) resetLoginThrottle(ctx context.Context, email string) error {
+	if !app.config.Login.Enabled {
+		return nil
+	}
+
+	err := app.models.LoginThrottles.Delete(ctx, model.EmailThrottleKey(email))
+	if err != nil && !errors.Is(err, model.ErrRecordNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// unlockUserHandler lifts the lockout of a user's account, and forgets its failed logins.
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.LoginThrottles.Delete(r.Context(), model.EmailThrottleKey(user.Email))
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.writeJSON(w, http.StatusOK, envelope{"message": "the account was not locked"}, nil)
+		return
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.PrintInfo("login unlocked", map[string]string{
+		"key":         model.EmailThrottleKey(user.Email),
+		"user_id":     strconv.FormatInt(user.ID, 10),
+		"unlocked_by": strconv.FormatInt(app.contextGetUser(r).ID, 10),
+	})
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "the account was unlocked"}, nil)
+}
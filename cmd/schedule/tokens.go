@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"time"
@@ -33,14 +34,24 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// Refuse the attempt outright if the email address or the client failed to log in too many
+	// times recently. Otherwise the attempt counts as failed until the password is checked.
+	attempt, ok := app.reserveLoginAttempt(w, r, input.Email)
+	if !ok {
+		return
+	}
+
 	// Lookup the user record based on the email address. If no matching user was found, then we
 	// call the app.invalidCredentialsResponse() helper to send a 501 Unauthorized response to
-	// the client.
-	user, err := app.models.Users.GetByEmail(input.Email)
+	// the client. Unknown emails are handled exactly like wrong passwords, down to the time the
+	// password check takes and the failure being counted, so that the response doesn't reveal
+	// whether the email is registered.
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
+			model.SimulatePasswordCheck(input.Password)
+			app.failedLoginResponse(w, r, attempt)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -54,24 +65,235 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	// If the passwords don't match, then call the app.invalidCredentialsResponse() helper
-	// and return
+	// If the passwords don't match, then call the app.failedLoginResponse() helper and return.
 	if !match {
-		app.invalidCredentialsResponse(w, r)
+		app.failedLoginResponse(w, r, attempt)
+		return
+	}
+
+	err = app.acceptPassword(r.Context(), attempt)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Users with two-factor authentication must also prove they hold their second factor, so
+	// they're only given a short-lived token to send along with their code.
+	secret, err := app.models.TOTP.Get(r.Context(), user.ID)
+	switch {
+	case err == nil && secret.Confirmed:
+		token, err := app.models.Tokens.New(r.Context(), user.ID, 5*time.Minute, model.ScopeTwoFactor)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusAccepted, envelope{"two_factor_token": token}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	case err != nil && !errors.Is(err, model.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.startSession(w, r, user)
+}
+
+// startSession logs the user in, with an authentication token and a refresh token that can be
+// exchanged for a new pair once the first one expires. The login is complete at this point, so
+// the failed logins for the user's email address are forgotten.
+func (app *application) startSession(w http.ResponseWriter, r *http.Request, user *model.User) {
+	err := app.resetLoginThrottle(r.Context(), user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	access, refresh, err := app.models.Tokens.NewPair(r.Context(), user.ID, app.config.accessTTL(), app.config.Tokens.RefreshTTL, r.UserAgent())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	access, err = app.accessToken(r.Context(), user, access)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Encode the tokens to JSON and send them in the response along with a 201 Created status
+	// code.
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": access, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// refreshAuthenticationTokenHandler exchanges a refresh token for a new pair of authentication
+// and refresh tokens. Each refresh token can only be used once: presenting it again revokes
+// every token of the session, since only someone who stole it would do that. The exception is a
+// retry within -token-refresh-grace, which is given the same pair again.
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if model.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	access, refresh, err := app.models.Tokens.Rotate(r.Context(), input.RefreshToken, app.config.accessTTL(), app.config.Tokens.RefreshTTL, app.config.Tokens.RefreshGrace, r.UserAgent())
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrTokenReused):
+			app.logger.PrintInfo("refresh token reused, session revoked", map[string]string{
+				"remote_addr": r.RemoteAddr,
+				"user_agent":  r.UserAgent(),
+			})
+			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	// Otherwise, if the password is correct, we generate a new token with a 24-hour expiry time
-	// and the scope 'authentication'.
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, model.ScopeAuthentication)
+	if app.config.Auth.Mode == authModeJWT {
+		user, err := app.models.Users.Get(r.Context(), access.UserID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		access, err = app.accessToken(r.Context(), user, access)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": access, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasswordResetTokenHandler issues a short-lived password reset token for the user with the
+// provided email address. The response is the same whether or not such a user exists, so that
+// the endpoint can't be used to find out which email addresses are registered.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if model.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "if the email address is registered, password reset instructions will be sent to it"}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.writeJSON(w, http.StatusAccepted, env, nil)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Otherwise, generate a new password reset token with a 45-minute expiry time.
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 45*time.Minute, model.ScopePasswordReset)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Encode the token to JSON and send it in the response along with a 201 Created status code.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	app.sendEmail(user.Email, "password_reset.tmpl", map[string]interface{}{
+		"passwordResetToken": token.Plaintext,
+		"name":               user.Name,
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
-}
\ No newline at end of file
+}
+
+// deleteAuthenticationTokenHandler logs the client out by revoking the session of the
+// authentication token the request was made with.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token := app.contextGetToken(r)
+	if token == nil {
+		app.badRequestResponse(w, r, errors.New("the request must be authenticated with a bearer token"))
+		return
+	}
+
+	// Signed access tokens aren't stored, so revoke the session they were issued for instead.
+	// The access token itself stays valid until it expires.
+	var err error
+	if token.Hash == nil {
+		err = app.models.Tokens.DeleteFamily(r.Context(), token.UserID, token.FamilyID)
+	} else {
+		err = app.models.Tokens.Delete(r.Context(), model.ScopeAuthentication, token.Hash)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
+}
+
+// deleteAllAuthenticationTokensHandler logs the user out everywhere by revoking every one of
+// their authentication and refresh tokens, including the one the request was made with.
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.revokeAllSessions(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out of every session"}, nil)
+}
+
+// revokeAllSessions deletes the authentication and refresh tokens of every session of a user.
+func (app *application) revokeAllSessions(ctx context.Context, userID int64) error {
+	for _, scope := range []string{model.ScopeAuthentication, model.ScopeRefresh} {
+		err := app.models.Tokens.DeleteAllForUser(ctx, scope, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/21b030939/golang-project/pkg/schedule/model"
+	"github.com/21b030939/golang-project/pkg/schedule/validator"
+	"github.com/21b030939/golang-project/pkg/totp"
+)
+
+const (
+	// totpSkew is the number of time steps before and after the current one whose codes are
+	// accepted, to allow for clock drift and slow typing.
+	totpSkew = 1
+	// maxTOTPDisableAttempts is the number of wrong codes a session may send to disable two-factor
+	// authentication. Past that, every session of the user is revoked, and disabling is refused
+	// until a code is accepted at login.
+	maxTOTPDisableAttempts = 5
+)
+
+// enrollTOTPHandler starts enabling two-factor authentication for the user. It generates a new
+// secret, returned both in base-32 and as an otpauth:// URI for authenticator apps. Two-factor
+// authentication is only enforced once the user confirms a first code.
+func (app *application) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	// Load the user, since in jwt mode the context only holds their ID.
+	user, err := app.models.Users.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TOTP.Enroll(r.Context(), user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrTOTPEnabled):
+			app.totpEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"totp": map[string]string{
+		"secret": totp.EncodeSecret(secret),
+		"uri":    totp.URI(app.config.TOTP.Issuer, user.Email, secret),
+	}}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmTOTPHandler enables two-factor authentication once the user sends a code generated
+// from the secret of enrollTOTPHandler. It responds with the recovery codes, which are only
+// shown this once.
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if validateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	secret, err := app.models.TOTP.Get(r.Context(), user.ID)
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.badRequestResponse(w, r, errors.New("two-factor authentication must be enrolled first"))
+		return
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	case secret.Confirmed:
+		app.totpEnabledResponse(w, r)
+		return
+	}
+
+	step, ok := totp.Validate(secret.Secret, input.Code, time.Now(), totpSkew)
+	if !ok {
+		app.invalidTwoFactorCodeResponse(w, r)
+		return
+	}
+
+	codes, err := app.models.TOTP.Confirm(r.Context(), user.ID, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.totpEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// disableTOTPHandler disables two-factor authentication. It requires a current code or a
+// recovery code, so that a stolen session isn't enough to remove the second factor, and only
+// allows a few wrong codes, so that the code can't be guessed either.
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input secondFactorInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if input.validate(v); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Count the attempt before checking the code, so that concurrent requests can't get more
+	// guesses than the limit. An accepted code resets the count.
+	attempts, err := app.models.TOTP.CountDisableAttempt(r.Context(), user.ID)
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		app.invalidTwoFactorCodeResponse(w, r)
+		return
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	ok := false
+	if attempts <= maxTOTPDisableAttempts {
+		ok, err = app.verifySecondFactor(r.Context(), user.ID, input)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if !ok {
+		if attempts < maxTOTPDisableAttempts {
+			app.invalidTwoFactorCodeResponse(w, r)
+			return
+		}
+
+		// The session is likely stolen: log every session out, so that the user has to log in
+		// again with their second factor.
+		err = app.revokeAllSessions(r.Context(), user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.logger.PrintInfo("too many attempts to disable two-factor authentication, sessions revoked", map[string]string{
+			"user_id":     strconv.FormatInt(user.ID, 10),
+			"remote_addr": r.RemoteAddr,
+		})
+		app.twoFactorAttemptsExceededResponse(w, r)
+		return
+	}
+
+	err = app.models.TOTP.Delete(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, model.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication was disabled"}, nil)
+}
+
+// createTwoFactorSessionHandler completes the login of a user with two-factor authentication:
+// it exchanges the token returned by createAuthenticationTokenHandler, along with a code or a
+// recovery code, for an authentication token. A wrong code revokes the token, so that codes
+// can't be guessed, and the user has to log in with their password again.
+func (app *application) createTwoFactorSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TwoFactorToken string `json:"two_factor_token"`
+		secondFactorInput
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	model.ValidateTokenPlaintext(v, input.TwoFactorToken)
+	input.validate(v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), model.ScopeTwoFactor, input.TwoFactorToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The token is single-use whether or not the code is right, so revoke it before checking
+	// the code.
+	err = app.models.Tokens.Delete(r.Context(), model.ScopeTwoFactor, model.HashTokenPlaintext(input.TwoFactorToken))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			// A concurrent request used the token first.
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ok, err := app.verifySecondFactor(r.Context(), user.ID, input.secondFactorInput)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		// The attempt reserved for the user's email address when the password was checked is
+		// left in place, so the wrong code counts as a failed login for the account.
+		app.logger.PrintInfo("two-factor code rejected", map[string]string{"user_id": strconv.FormatInt(user.ID, 10)})
+		app.invalidTwoFactorCodeResponse(w, r)
+		return
+	}
+
+	app.startSession(w, r, user)
+}
+
+// secondFactorInput is the proof of the second factor: either a TOTP code or a recovery code.
+type secondFactorInput struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func (input secondFactorInput) validate(v *validator.Validator) {
+	switch {
+	case input.Code == "" && input.RecoveryCode == "":
+		v.AddError("code", "a code or a recovery code must be provided")
+	case input.Code != "" && input.RecoveryCode != "":
+		v.AddError("code", "must not be provided along with a recovery code")
+	case input.Code != "":
+		validateTOTPCode(v, input.Code)
+	default:
+		v.Check(len(input.RecoveryCode) <= 16, "recovery_code", "must not be more than 16 bytes long")
+	}
+}
+
+func validateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == totp.Digits, "code", "must be 6 digits long")
+}
+
+// verifySecondFactor checks the code or recovery code of a user with two-factor authentication.
+// Accepted codes can't be used again.
+func (app *application) verifySecondFactor(ctx context.Context, userID int64, input secondFactorInput) (bool, error) {
+	if input.RecoveryCode != "" {
+		err := app.models.TOTP.UseRecoveryCode(ctx, userID, input.RecoveryCode)
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			return false, nil
+		case err != nil:
+			return false, err
+		}
+		return true, nil
+	}
+
+	secret, err := app.models.TOTP.Get(ctx, userID)
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	case !secret.Confirmed:
+		return false, nil
+	}
+
+	step, ok := totp.Validate(secret.Secret, input.Code, time.Now(), totpSkew)
+	if !ok {
+		return false, nil
+	}
+
+	// Record the step, which fails if its code, or a later one, was already used.
+	err = app.models.TOTP.UseStep(ctx, userID, step)
+	switch {
+	case errors.Is(err, model.ErrRecordNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	return true, nil
+}
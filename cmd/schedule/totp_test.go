@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/21b030939/golang-project/pkg/schedule/validator"
+)
+
+func TestSecondFactorInputValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     secondFactorInput
+		wantField string
+	}{
+		{"code", secondFactorInput{Code: "123456"}, ""},
+		{"recovery code", secondFactorInput{RecoveryCode: "ABCDE-FGHJK"}, ""},
+		{"neither", secondFactorInput{}, "code"},
+		{"both", secondFactorInput{Code: "123456", RecoveryCode: "ABCDE-FGHJK"}, "code"},
+		{"short code", secondFactorInput{Code: "12345"}, "code"},
+		{"long recovery code", secondFactorInput{RecoveryCode: "ABCDE-FGHJK-MNPQR"}, "recovery_code"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			tt.input.validate(v)
+
+			if tt.wantField == "" {
+				if !v.Valid() {
+					t.Errorf("unexpected errors: %v", v.Errors)
+				}
+				return
+			}
+			if _, ok := v.Errors[tt.wantField]; !ok {
+				t.Errorf("no error for %q: %v", tt.wantField, v.Errors)
+			}
+		})
+	}
+}
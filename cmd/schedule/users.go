@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"net/http"
 	"time"
@@ -51,7 +52,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Insert the user data into the database.
-	err = app.models.Users.Insert(user)
+	err = app.models.Users.Insert(r.Context(), user)
 	if err != nil {
 		switch {
 		// If we get an ErrDuplicateEmail error, use the v.AddError() method to manually add
@@ -67,7 +68,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "schedules:read")
+	// New users are students until an administrator assigns them another role.
+	err = app.models.Roles.AddForUser(r.Context(), user.ID, model.RoleStudent)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -75,21 +77,21 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	// After the user record has been created in the database, generate a new activation
 	// token for the user.
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, model.ScopeActivation)
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, model.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	var res struct {
-		Token *string     `json:"token"`
-		User  *model.User `json:"user"`
-	}
-
-	res.Token = &token.Plaintext
-	res.User = user
+	// Email the activation token to the user instead of returning it, so that only the owner
+	// of the email address can activate the account.
+	app.sendEmail(user.Email, "user_welcome.tmpl", map[string]interface{}{
+		"activationToken": token.Plaintext,
+		"userID":          user.ID,
+		"name":            user.Name,
+	})
 
-	app.writeJSON(w, http.StatusCreated, envelope{"user": res}, nil)
+	app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
 }
 
 // activateUserHandler activates a user by setting 'activation = true' using the provided
@@ -117,7 +119,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	// Retrieve the details of the user associated with the token using the GetForToken() method.
 	// If no matching record is found, then we let the client know that the token they provided
 	// is not valid.
-	user, err := app.models.Users.GetForToken(model.ScopeActivation, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(r.Context(), model.ScopeActivation, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrRecordNotFound):
@@ -134,7 +136,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 	// Save the updated user record in our database, checking for any edit conflicts in the same
 	// way that we did for our move records.
-	err = app.models.Users.Update(user)
+	err = app.models.Users.Update(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrEditConflict):
@@ -146,7 +148,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// If everything went successfully above, then delete all activation tokens for the user.
-	err = app.models.Tokens.DeleteAllForUser(model.ScopeActivation, user.ID)
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), model.ScopeActivation, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -154,3 +156,158 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 	app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 }
+
+// updateUserPasswordHandler sets a new password for the user identified by a password reset
+// token. Every existing authentication token of the user is revoked, so sessions opened with the
+// old password can't be used any more.
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	model.ValidatePasswordPlaintext(v, input.Password)
+	model.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), model.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The reset token is single-use, and the old password must not keep any session alive.
+	for _, scope := range []string{model.ScopePasswordReset, model.ScopeAuthentication, model.ScopeRefresh} {
+		err = app.models.Tokens.DeleteAllForUser(r.Context(), scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+}
+
+// listUserSessionsHandler lists the active sessions (unexpired authentication tokens, or refresh
+// tokens in jwt mode) of the current user.
+func (app *application) listUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	current := app.contextGetToken(r)
+
+	tokens, err := app.models.Tokens.GetAllForUser(r.Context(), app.sessionScope(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"sessions": userSessions(current, tokens)}, nil)
+}
+
+// userSession is a session as listed to its user. The token itself is never included.
+type userSession struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Expiry    time.Time `json:"expiry"`
+	UserAgent string    `json:"user_agent"`
+	Current   bool      `json:"current"`
+}
+
+// userSessions returns the sessions recorded by tokens, marking the one of current, the token the
+// request was authenticated with. The result is never nil, so that it's encoded as an empty list.
+func userSessions(current *model.Token, tokens []*model.Token) []userSession {
+	sessions := make([]userSession, 0, len(tokens))
+	for _, token := range tokens {
+		sessions = append(sessions, userSession{
+			ID:        token.ID,
+			CreatedAt: token.CreatedAt,
+			Expiry:    token.Expiry,
+			UserAgent: token.UserAgent,
+			Current:   isCurrentSession(current, token),
+		})
+	}
+
+	return sessions
+}
+
+// deleteUserSessionHandler revokes a single session of the current user by its id.
+func (app *application) deleteUserSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Tokens.DeleteForUser(r.Context(), app.sessionScope(), user.ID, int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"message": "session revoked"}, nil)
+}
+
+// sessionScope returns the scope of the tokens that record sessions: the authentication tokens,
+// except in jwt mode, where they're signed and not stored, and sessions are recorded by their
+// refresh token.
+func (app *application) sessionScope() string {
+	if app.config.Auth.Mode == authModeJWT {
+		return model.ScopeRefresh
+	}
+
+	return model.ScopeAuthentication
+}
+
+// isCurrentSession reports whether token records the session of current, the token the request
+// was authenticated with. Signed access tokens have no hash, and name their session instead.
+func isCurrentSession(current, token *model.Token) bool {
+	switch {
+	case current == nil:
+		return false
+	case current.Hash == nil:
+		return current.FamilyID != 0 && current.FamilyID == token.FamilyID
+	default:
+		return bytes.Equal(current.Hash, token.Hash)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/21b030939/golang-project/pkg/schedule/model"
+)
+
+func TestUserSessions(t *testing.T) {
+	created := time.Date(2025, 9, 1, 8, 0, 0, 0, time.UTC)
+
+	tokens := []*model.Token{
+		{ID: 3, Plaintext: "secret", Hash: []byte("phone"), CreatedAt: created, Expiry: created.Add(24 * time.Hour), UserAgent: "phone"},
+		{ID: 5, Hash: []byte("laptop"), CreatedAt: created, Expiry: created.Add(24 * time.Hour), UserAgent: "laptop"},
+	}
+	current := &model.Token{Hash: []byte("laptop")}
+
+	sessions := userSessions(current, tokens)
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(sessions))
+	}
+	for i, want := range []struct {
+		id      int64
+		agent   string
+		current bool
+	}{{3, "phone", false}, {5, "laptop", true}} {
+		got := sessions[i]
+		if got.ID != want.id || got.UserAgent != want.agent || got.Current != want.current {
+			t.Errorf("sessions[%d] = %+v, want id %d, user agent %q, current %v", i, got, want.id, want.agent, want.current)
+		}
+		if !got.CreatedAt.Equal(created) || !got.Expiry.Equal(created.Add(24*time.Hour)) {
+			t.Errorf("sessions[%d] = %+v, want the times of the token", i, got)
+		}
+	}
+
+	js, err := json.Marshal(sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(js), "secret") || strings.Contains(string(js), "token") {
+		t.Errorf("sessions expose the token: %s", js)
+	}
+
+	js, err = json.Marshal(userSessions(nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(js) != "[]" {
+		t.Errorf("no sessions: got %s, want []", js)
+	}
+}
+
+func TestSessionHandlers(t *testing.T) {
+	serve := newTestRouter(t)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{"list", http.MethodGet, "/api/v1/users/me/sessions", http.StatusInternalServerError, ""},
+		{"revoke an invalid id", http.MethodDelete, "/api/v1/users/me/sessions/0", http.StatusNotFound, ""},
+		{"revoke", http.MethodDelete, "/api/v1/users/me/sessions/3", http.StatusInternalServerError, ""},
+		{"log out without a token", http.MethodDelete, "/api/v1/tokens/authentication", http.StatusBadRequest, "must be authenticated with a bearer token"},
+		{"log out everywhere", http.MethodDelete, "/api/v1/tokens/authentication/all", http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.method, tt.path, "")
+
+			if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("status = %d, body = %s, want %d containing %q", w.Code, w.Body, tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+}
@@ -3,14 +3,18 @@ module github.com/21b030939/golang-project
 go 1.21.6
 
 require (
-	github.com/golang-migrate/migrate/v4 v4.17.1 // indirect
-	github.com/gorilla/mux v1.8.1 // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/golang-migrate/migrate/v4 v4.17.1
+	github.com/gorilla/mux v1.8.1
+	github.com/jmoiron/sqlx v1.4.0
+	github.com/lib/pq v1.10.9
+	github.com/peterbourgon/ff/v3 v3.4.0
+	golang.org/x/crypto v0.21.0
+	golang.org/x/time v0.5.0
+)
+
+require (
 	github.com/hashicorp/errwrap v1.1.0 // indirect
 	github.com/hashicorp/go-multierror v1.1.1 // indirect
-	github.com/jmoiron/sqlx v1.4.0 // indirect
-	github.com/lib/pq v1.10.9 // indirect
-	github.com/peterbourgon/ff/v3 v3.4.0 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
-	golang.org/x/crypto v0.21.0 // indirect
-
 )
@@ -0,0 +1,192 @@
+// Package cache provides a bounded in-memory LRU cache whose entries expire after a TTL.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds the counters of a cache.
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Size      int    `json:"size"`
+}
+
+// Cache is a least recently used cache holding at most size entries, each of which expires after
+// at most ttl. It is safe for concurrent use. A nil *Cache is valid and caches nothing, so callers
+// can disable caching without checking for it everywhere.
+type Cache[K comparable, V any] struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[K]*list.Element
+
+	// generation is incremented on every invalidation. GetOrLoad only stores a loaded value if
+	// no invalidation happened while it was being loaded, otherwise a lookup racing with, say,
+	// a revoked token could put the revoked token back into the cache.
+	generation uint64
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// New returns a cache holding at most size entries for at most ttl each.
+func New[K comparable, V any](size int, ttl time.Duration) *Cache[K, V] {
+	return &Cache[K, V]{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[K]*list.Element),
+	}
+}
+
+// Get returns the value cached for key, if there is one that hasn't expired.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	var zero V
+	if c == nil {
+		return zero, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.misses.Add(1)
+		return zero, false
+	}
+
+	e := el.Value.(*entry[K, V])
+	if time.Now().After(e.expires) {
+		c.remove(el)
+		c.misses.Add(1)
+		return zero, false
+	}
+
+	c.ll.MoveToFront(el)
+	c.hits.Add(1)
+	return e.value, true
+}
+
+// GetOrLoad returns the value cached for key. On a miss it calls load and caches the value it
+// returns until the earlier of the TTL and the expiry returned by load; a zero expiry means the
+// TTL alone applies. Errors from load are returned as they are and nothing is cached.
+func (c *Cache[K, V]) GetOrLoad(key K, load func() (V, time.Time, error)) (V, error) {
+	if value, ok := c.Get(key); ok {
+		return value, nil
+	}
+
+	var generation uint64
+	if c != nil {
+		c.mu.Lock()
+		generation = c.generation
+		c.mu.Unlock()
+	}
+
+	value, expires, err := load()
+	if err != nil || c == nil {
+		return value, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation == generation {
+		c.set(key, value, expires)
+	}
+
+	return value, nil
+}
+
+// Delete removes the entry for key.
+func (c *Cache[K, V]) Delete(key K) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+// DeleteFunc removes every entry for which fn returns true.
+func (c *Cache[K, V]) DeleteFunc(fn func(key K, value V) bool) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		e := el.Value.(*entry[K, V])
+		if fn(e.key, e.value) {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+// Stats returns the current counters of the cache.
+func (c *Cache[K, V]) Stats() Stats {
+	if c == nil {
+		return Stats{}
+	}
+
+	c.mu.Lock()
+	size := c.ll.Len()
+	c.mu.Unlock()
+
+	return Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Size:      size,
+	}
+}
+
+// set stores the value, evicting the least recently used entry if the cache is full. The caller
+// must hold c.mu.
+func (c *Cache[K, V]) set(key K, value V, expires time.Time) {
+	if deadline := time.Now().Add(c.ttl); expires.IsZero() || deadline.Before(expires) {
+		expires = deadline
+	}
+
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry[K, V])
+		e.value, e.expires = value, expires
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&entry[K, V]{key: key, value: value, expires: expires})
+
+	for c.ll.Len() > c.size {
+		c.remove(c.ll.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// remove deletes the list element and its map entry. The caller must hold c.mu.
+func (c *Cache[K, V]) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*entry[K, V]).key)
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCacheGet(t *testing.T) {
+	c := New[string, int](2, time.Minute)
+
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("got a value from an empty cache")
+	}
+
+	c.set("a", 1, time.Time{})
+	c.set("b", 2, time.Time{})
+
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("Get(a) = %d, %v, want 1, true", v, ok)
+	}
+
+	// "b" is now the least recently used entry, so it's the one evicted.
+	c.set("c", 3, time.Time{})
+
+	tests := []struct {
+		key    string
+		want   int
+		wantOK bool
+	}{
+		{"a", 1, true},
+		{"b", 0, false},
+		{"c", 3, true},
+	}
+
+	for _, tt := range tests {
+		if v, ok := c.Get(tt.key); v != tt.want || ok != tt.wantOK {
+			t.Errorf("Get(%s) = %d, %v, want %d, %v", tt.key, v, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	want := Stats{Hits: 3, Misses: 2, Evictions: 1, Size: 2}
+	if got := c.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		expires time.Time
+		wantOK  bool
+	}{
+		{"within the TTL", time.Minute, time.Time{}, true},
+		{"past the TTL", -time.Second, time.Time{}, false},
+		{"past the expiry of the value", time.Minute, time.Now().Add(-time.Second), false},
+		{"expiry of the value later than the TTL", -time.Second, time.Now().Add(time.Hour), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New[string, int](10, tt.ttl)
+			c.set("a", 1, tt.expires)
+
+			if _, ok := c.Get("a"); ok != tt.wantOK {
+				t.Errorf("Get(a) ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !tt.wantOK && c.Stats().Size != 0 {
+				t.Errorf("expired entry wasn't removed")
+			}
+		})
+	}
+}
+
+func TestCacheGetOrLoad(t *testing.T) {
+	c := New[string, int](10, time.Minute)
+
+	calls := 0
+	load := func() (int, time.Time, error) {
+		calls++
+		return 42, time.Time{}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		v, err := c.GetOrLoad("a", load)
+		if err != nil || v != 42 {
+			t.Fatalf("GetOrLoad = %d, %v, want 42, nil", v, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("load called %d times, want 1", calls)
+	}
+
+	errLoad := errors.New("load failed")
+	_, err := c.GetOrLoad("b", func() (int, time.Time, error) { return 0, time.Time{}, errLoad })
+	if !errors.Is(err, errLoad) {
+		t.Errorf("GetOrLoad error = %v, want %v", err, errLoad)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Error("a failed load was cached")
+	}
+}
+
+func TestCacheGetOrLoadInvalidated(t *testing.T) {
+	c := New[string, int](10, time.Minute)
+
+	// A Delete while the value is being loaded means the loaded value may already be stale.
+	v, err := c.GetOrLoad("a", func() (int, time.Time, error) {
+		c.Delete("a")
+		return 1, time.Time{}, nil
+	})
+	if err != nil || v != 1 {
+		t.Fatalf("GetOrLoad = %d, %v, want 1, nil", v, err)
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Error("value loaded during an invalidation was cached")
+	}
+}
+
+func TestCacheDeleteFunc(t *testing.T) {
+	c := New[int, string](10, time.Minute)
+	for i := 1; i <= 5; i++ {
+		c.set(i, "user", time.Time{})
+	}
+
+	c.DeleteFunc(func(key int, _ string) bool { return key%2 == 0 })
+
+	for i := 1; i <= 5; i++ {
+		if _, ok := c.Get(i); ok != (i%2 != 0) {
+			t.Errorf("Get(%d) ok = %v", i, ok)
+		}
+	}
+
+	c.Delete(1)
+	if _, ok := c.Get(1); ok {
+		t.Error("Get(1) found a deleted entry")
+	}
+}
+
+func TestNilCache(t *testing.T) {
+	var c *Cache[string, int]
+
+	v, err := c.GetOrLoad("a", func() (int, time.Time, error) { return 1, time.Time{}, nil })
+	if err != nil || v != 1 {
+		t.Errorf("GetOrLoad = %d, %v, want 1, nil", v, err)
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Error("a nil cache returned a value")
+	}
+
+	c.Delete("a")
+	c.DeleteFunc(func(string, int) bool { return true })
+
+	if got := c.Stats(); got != (Stats{}) {
+		t.Errorf("Stats() = %+v, want zero", got)
+	}
+}
@@ -0,0 +1,258 @@
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Calendar is a minimal RFC 5545 calendar holding recurring events.
+type Calendar struct {
+	// ProdID identifies the product that created the calendar.
+	ProdID string
+	// Name is shown by calendar clients as the name of the subscribed calendar.
+	Name string
+	// Location is the time zone in which the event start and end times are expressed.
+	Location *time.Location
+	Events   []Event
+}
+
+// Event is a single VEVENT. If Recurrence is not empty, it is written as the event's RRULE.
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Location    string
+	Start       time.Time
+	End         time.Time
+	Recurrence  Recurrence
+}
+
+// Recurrence describes a weekly RRULE. Interval is 1 for every week and 2 for every other week.
+type Recurrence struct {
+	Interval int
+	Until    time.Time
+}
+
+// String formats the recurrence as an RRULE value, e.g. "FREQ=WEEKLY;INTERVAL=2;UNTIL=...".
+func (r Recurrence) String() string {
+	rule := fmt.Sprintf("FREQ=WEEKLY;INTERVAL=%d", r.Interval)
+	if !r.Until.IsZero() {
+		rule += ";UNTIL=" + r.Until.UTC().Format("20060102T150405Z")
+	}
+
+	return rule
+}
+
+// WriteTo writes the calendar in iCalendar format to w.
+func (c *Calendar) WriteTo(w io.Writer) (int64, error) {
+	cw := &contentWriter{w: bufio.NewWriter(w)}
+
+	cw.line("BEGIN:VCALENDAR")
+	cw.line("VERSION:2.0")
+	cw.line("PRODID:" + escape(c.ProdID))
+	cw.line("CALSCALE:GREGORIAN")
+	cw.line("METHOD:PUBLISH")
+	if c.Name != "" {
+		cw.line("X-WR-CALNAME:" + escape(c.Name))
+	}
+	cw.line("X-WR-TIMEZONE:" + c.Location.String())
+	c.writeTimezone(cw)
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	for _, e := range c.Events {
+		cw.line("BEGIN:VEVENT")
+		cw.line("UID:" + escape(e.UID))
+		cw.line("DTSTAMP:" + stamp)
+		cw.line(fmt.Sprintf("DTSTART;TZID=%s:%s", c.Location, e.Start.In(c.Location).Format("20060102T150405")))
+		cw.line(fmt.Sprintf("DTEND;TZID=%s:%s", c.Location, e.End.In(c.Location).Format("20060102T150405")))
+		if e.Recurrence.Interval > 0 {
+			cw.line("RRULE:" + e.Recurrence.String())
+		}
+		cw.line("SUMMARY:" + escape(e.Summary))
+		if e.Location != "" {
+			cw.line("LOCATION:" + escape(e.Location))
+		}
+		if e.Description != "" {
+			cw.line("DESCRIPTION:" + escape(e.Description))
+		}
+		cw.line("END:VEVENT")
+	}
+
+	cw.line("END:VCALENDAR")
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.Flush()
+}
+
+// maxTimezoneSpan caps the period whose offset transitions are written in the VTIMEZONE, in case
+// an event recurs without an end.
+const maxTimezoneSpan = 5 * 366 * 24 * time.Hour
+
+// writeTimezone writes the VTIMEZONE component that the TZID parameters of the events refer to,
+// as RFC 5545 requires. It describes the offset of the location when the first event starts and
+// every offset transition until the last event ends or stops recurring.
+func (c *Calendar) writeTimezone(cw *contentWriter) {
+	from, to := c.span()
+
+	cw.line("BEGIN:VTIMEZONE")
+	cw.line("TZID:" + c.Location.String())
+
+	// The first observance covers the period before the first transition.
+	_, offset := from.In(c.Location).Zone()
+	writeObservance(cw, from.In(c.Location), offset)
+
+	for t := from; t.Before(to); {
+		next, ok := nextTransition(t, to, c.Location)
+		if !ok {
+			break
+		}
+
+		_, previous := next.Add(-time.Second).In(c.Location).Zone()
+		writeObservance(cw, next.In(c.Location), previous)
+		t = next
+	}
+
+	cw.line("END:VTIMEZONE")
+}
+
+// span returns the period the events of the calendar take place in.
+func (c *Calendar) span() (time.Time, time.Time) {
+	var from, to time.Time
+
+	for _, e := range c.Events {
+		end := e.End
+		if e.Recurrence.Interval > 0 {
+			end = e.Recurrence.Until
+			if end.IsZero() {
+				end = e.Start.Add(maxTimezoneSpan)
+			}
+		}
+
+		if from.IsZero() || e.Start.Before(from) {
+			from = e.Start
+		}
+		if end.After(to) {
+			to = end
+		}
+	}
+
+	if from.IsZero() {
+		from = time.Now()
+	}
+	if to.Sub(from) > maxTimezoneSpan {
+		to = from.Add(maxTimezoneSpan)
+	}
+
+	return from, to
+}
+
+// writeObservance writes a STANDARD or DAYLIGHT component for the offset in effect from t on,
+// previous being the offset before t.
+func writeObservance(cw *contentWriter, t time.Time, previous int) {
+	name, offset := t.Zone()
+
+	component := "STANDARD"
+	if t.IsDST() {
+		component = "DAYLIGHT"
+	}
+
+	cw.line("BEGIN:" + component)
+	// DTSTART is the local time of the transition, in the offset before it.
+	cw.line("DTSTART:" + t.UTC().Add(time.Duration(previous)*time.Second).Format("20060102T150405"))
+	cw.line("TZOFFSETFROM:" + formatOffset(previous))
+	cw.line("TZOFFSETTO:" + formatOffset(offset))
+	if name != "" {
+		cw.line("TZNAME:" + escape(name))
+	}
+	cw.line("END:" + component)
+}
+
+// nextTransition returns the first instant after t and before to at which the offset of loc
+// changes. It checks the offset every day and then bisects to the second, which is enough since
+// time zones don't change their offset twice in a day.
+func nextTransition(t, to time.Time, loc *time.Location) (time.Time, bool) {
+	_, offset := t.In(loc).Zone()
+
+	for day := t.Add(24 * time.Hour); ; day = day.Add(24 * time.Hour) {
+		if day.After(to) {
+			day = to
+		}
+
+		if _, o := day.In(loc).Zone(); o != offset {
+			// The transition is in (day-24h, day], narrow it down to the second.
+			lo, hi := day.Add(-24*time.Hour), day
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2)
+				if _, o := mid.In(loc).Zone(); o == offset {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			return hi, true
+		}
+
+		if !day.Before(to) {
+			return time.Time{}, false
+		}
+	}
+}
+
+// formatOffset formats a UTC offset in seconds as a UTC-OFFSET value, e.g. "+0600".
+func formatOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+
+	s := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+	if seconds%60 != 0 {
+		s += fmt.Sprintf("%02d", seconds%60)
+	}
+
+	return s
+}
+
+// contentWriter writes content lines terminated with CRLF, folding lines longer than 75 octets
+// as required by RFC 5545. The first error is remembered and all later writes are skipped.
+type contentWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *contentWriter) line(s string) {
+	// Continuation lines start with a space, which counts towards their 75 octets.
+	limit := 75
+	for len(s) > limit {
+		// Don't split a multi-byte UTF-8 sequence across two lines.
+		cut := limit
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		cw.write(s[:cut] + "\r\n ")
+		s = s[cut:]
+		limit = 74
+	}
+	cw.write(s + "\r\n")
+}
+
+func (cw *contentWriter) write(s string) {
+	if cw.err != nil {
+		return
+	}
+	n, err := cw.w.WriteString(s)
+	cw.n += int64(n)
+	cw.err = err
+}
+
+// escape escapes a TEXT property value.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,189 @@
+package ical
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestRecurrenceString(t *testing.T) {
+	tests := []struct {
+		name       string
+		recurrence Recurrence
+		want       string
+	}{
+		{"weekly", Recurrence{Interval: 1}, "FREQ=WEEKLY;INTERVAL=1"},
+		{"every other week", Recurrence{Interval: 2}, "FREQ=WEEKLY;INTERVAL=2"},
+		{
+			"until in UTC",
+			Recurrence{Interval: 1, Until: time.Date(2025, 5, 31, 23, 59, 59, 0, time.FixedZone("", 6*3600))},
+			"FREQ=WEEKLY;INTERVAL=1;UNTIL=20250531T175959Z",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.recurrence.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatOffset(t *testing.T) {
+	tests := []struct {
+		seconds int
+		want    string
+	}{
+		{0, "+0000"},
+		{6 * 3600, "+0600"},
+		{-5 * 3600, "-0500"},
+		{5*3600 + 45*60, "+0545"},
+		{-(3*3600 + 30*60), "-0330"},
+		{1*3600 + 2*60 + 3, "+010203"},
+	}
+
+	for _, tt := range tests {
+		if got := formatOffset(tt.seconds); got != tt.want {
+			t.Errorf("formatOffset(%d) = %q, want %q", tt.seconds, got, tt.want)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Algorithms", "Algorithms"},
+		{"Lecture; room 101, block A", `Lecture\; room 101\, block A`},
+		{`C:\path`, `C:\\path`},
+		{"line 1\nline 2\r\nline 3", `line 1\nline 2\nline 3`},
+	}
+
+	for _, tt := range tests {
+		if got := escape(tt.in); got != tt.want {
+			t.Errorf("escape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestContentWriterFolding(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"short", "SUMMARY:Algorithms"},
+		{"exactly 75 octets", "SUMMARY:" + strings.Repeat("a", 67)},
+		{"long", "DESCRIPTION:" + strings.Repeat("abcdefghij", 20)},
+		{"multi-byte", "SUMMARY:" + strings.Repeat("Алгоритмы ", 15)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			cw := &contentWriter{w: bufio.NewWriter(&buf)}
+			cw.line(tt.line)
+			cw.w.Flush()
+
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+			var unfolded strings.Builder
+			for i, line := range lines {
+				if len(line) > 75 {
+					t.Errorf("line %d is %d octets long", i, len(line))
+				}
+				if i > 0 {
+					if !strings.HasPrefix(line, " ") {
+						t.Fatalf("continuation line %d doesn't start with a space: %q", i, line)
+					}
+					line = line[1:]
+				}
+				unfolded.WriteString(line)
+			}
+
+			if unfolded.String() != tt.line {
+				t.Errorf("unfolded line = %q, want %q", unfolded.String(), tt.line)
+			}
+		})
+	}
+}
+
+func TestCalendarTimezone(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		start    time.Time
+		until    time.Time
+		want     []string
+		notWant  []string
+	}{
+		{
+			name:     "no transitions",
+			location: "Asia/Tokyo",
+			start:    time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC),
+			until:    time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC),
+			want: []string{
+				"BEGIN:VTIMEZONE\r\nTZID:Asia/Tokyo\r\nBEGIN:STANDARD\r\nDTSTART:20250106T090000\r\n" +
+					"TZOFFSETFROM:+0900\r\nTZOFFSETTO:+0900\r\nTZNAME:JST\r\nEND:STANDARD\r\nEND:VTIMEZONE\r\n",
+				"DTSTART;TZID=Asia/Tokyo:20250106T090000",
+			},
+			notWant: []string{"DAYLIGHT"},
+		},
+		{
+			name:     "daylight saving time",
+			location: "America/New_York",
+			start:    time.Date(2025, 1, 6, 14, 0, 0, 0, time.UTC),
+			until:    time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC),
+			want: []string{
+				"BEGIN:DAYLIGHT\r\nDTSTART:20250309T020000\r\nTZOFFSETFROM:-0500\r\nTZOFFSETTO:-0400\r\nTZNAME:EDT\r\nEND:DAYLIGHT\r\n",
+				"BEGIN:STANDARD\r\nDTSTART:20251102T020000\r\nTZOFFSETFROM:-0400\r\nTZOFFSETTO:-0500\r\nTZNAME:EST\r\nEND:STANDARD\r\n",
+				"DTSTART;TZID=America/New_York:20250106T090000",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := time.LoadLocation(tt.location)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cal := &Calendar{
+				ProdID:   "-//test//EN",
+				Location: loc,
+				Events: []Event{{
+					UID:        "1@test",
+					Summary:    "Algorithms",
+					Start:      tt.start,
+					End:        tt.start.Add(50 * time.Minute),
+					Recurrence: Recurrence{Interval: 1, Until: tt.until},
+				}},
+			}
+
+			var buf bytes.Buffer
+			if _, err := cal.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+
+			out := buf.String()
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("output doesn't contain %q:\n%s", want, out)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(out, notWant) {
+					t.Errorf("output contains %q:\n%s", notWant, out)
+				}
+			}
+
+			// The VTIMEZONE must come before the events that refer to it.
+			if strings.Index(out, "BEGIN:VTIMEZONE") > strings.Index(out, "BEGIN:VEVENT") {
+				t.Errorf("VTIMEZONE written after the events:\n%s", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+// Package jwtauth signs and verifies JSON Web Tokens with a set of keys identified by key ID, so
+// that keys can be rotated: tokens are signed with one key and verified with whichever key their
+// "kid" header names, until the tokens signed with a retired key have all expired.
+package jwtauth
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MinHMACKeySize is the minimum size, in bytes, of an HS256 secret.
+const MinHMACKeySize = 32
+
+// ErrInvalidToken is returned by Verify for tokens that are malformed, signed with an unknown key,
+// carry a bad signature or fail claim validation, such as expired tokens.
+var ErrInvalidToken = errors.New("invalid token")
+
+type key struct {
+	method jwt.SigningMethod
+	// sign is nil for keys that can only verify, such as the public half of an Ed25519 key.
+	sign   interface{}
+	verify interface{}
+}
+
+// KeySet holds the keys tokens are verified with, and the key new tokens are signed with.
+type KeySet struct {
+	keys       map[string]key
+	signingKID string
+	issuer     string
+}
+
+// ParseKeys parses keys in the format "kid=alg:base64", where alg is one of
+//
+//	hs256           an HMAC-SHA256 secret of at least MinHMACKeySize bytes
+//	ed25519         an Ed25519 private key, as a 32-byte seed or a 64-byte private key
+//	ed25519-public  a 32-byte Ed25519 public key, which can verify tokens but not sign them
+//
+// Tokens are signed with the key signingKID, or with the first key if signingKID is empty, and
+// carry issuer in their "iss" claim. Tokens from another issuer are rejected.
+func ParseKeys(specs []string, signingKID, issuer string) (*KeySet, error) {
+	if len(specs) == 0 {
+		return nil, errors.New("at least one key must be provided")
+	}
+
+	ks := &KeySet{keys: make(map[string]key), signingKID: signingKID, issuer: issuer}
+
+	for _, spec := range specs {
+		kid, rest, ok := strings.Cut(spec, "=")
+		if !ok || kid == "" {
+			return nil, fmt.Errorf("key %q must be in the format kid=alg:base64", redact(spec))
+		}
+		if _, exists := ks.keys[kid]; exists {
+			return nil, fmt.Errorf("key %q is defined more than once", kid)
+		}
+
+		alg, encoded, ok := strings.Cut(rest, ":")
+		if !ok {
+			return nil, fmt.Errorf("key %q must be in the format kid=alg:base64", kid)
+		}
+
+		material, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q must be base64 encoded", kid)
+		}
+
+		k, err := newKey(alg, material)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", kid, err)
+		}
+		ks.keys[kid] = k
+
+		if ks.signingKID == "" {
+			ks.signingKID = kid
+		}
+	}
+
+	k, ok := ks.keys[ks.signingKID]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("signing key %q is not defined", ks.signingKID)
+	case k.sign == nil:
+		return nil, fmt.Errorf("signing key %q can only verify tokens", ks.signingKID)
+	}
+
+	return ks, nil
+}
+
+func newKey(alg string, material []byte) (key, error) {
+	switch alg {
+	case "hs256":
+		if len(material) < MinHMACKeySize {
+			return key{}, fmt.Errorf("hs256 secret must be at least %d bytes long", MinHMACKeySize)
+		}
+		return key{method: jwt.SigningMethodHS256, sign: material, verify: material}, nil
+
+	case "ed25519":
+		var private ed25519.PrivateKey
+		switch len(material) {
+		case ed25519.SeedSize:
+			private = ed25519.NewKeyFromSeed(material)
+		case ed25519.PrivateKeySize:
+			private = ed25519.PrivateKey(material)
+		default:
+			return key{}, fmt.Errorf("ed25519 private key must be %d or %d bytes long", ed25519.SeedSize, ed25519.PrivateKeySize)
+		}
+		return key{method: jwt.SigningMethodEdDSA, sign: private, verify: private.Public()}, nil
+
+	case "ed25519-public":
+		if len(material) != ed25519.PublicKeySize {
+			return key{}, fmt.Errorf("ed25519 public key must be %d bytes long", ed25519.PublicKeySize)
+		}
+		return key{method: jwt.SigningMethodEdDSA, verify: ed25519.PublicKey(material)}, nil
+
+	default:
+		return key{}, fmt.Errorf("unknown algorithm %q, must be hs256, ed25519 or ed25519-public", alg)
+	}
+}
+
+// Issuer returns the issuer tokens must name in their "iss" claim.
+func (ks *KeySet) Issuer() string {
+	return ks.issuer
+}
+
+// Sign returns claims as a token signed with the signing key. The claims must name the issuer of
+// the key set, see Issuer, since Verify rejects tokens from any other issuer.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if issuer, err := claims.GetIssuer(); err != nil || issuer != ks.issuer {
+		return "", fmt.Errorf("jwtauth: claims must have issuer %q", ks.issuer)
+	}
+
+	k := ks.keys[ks.signingKID]
+
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = ks.signingKID
+
+	return token.SignedString(k.sign)
+}
+
+// Verify checks the signature of a token with the key named by its "kid" header, and its
+// expiry, not-before and issuer claims, then decodes its claims into claims. Every failure is
+// reported as ErrInvalidToken.
+func (ks *KeySet) Verify(tokenString string, claims jwt.Claims) error {
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		k, ok := ks.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+
+		// Only accept the algorithm of the key, or an attacker could for instance pass off an
+		// Ed25519 public key as an HMAC secret.
+		if token.Method.Alg() != k.method.Alg() {
+			return nil, fmt.Errorf("unexpected algorithm %q for key %q", token.Method.Alg(), kid)
+		}
+
+		return k.verify, nil
+	}
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, keyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(ks.issuer),
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	return nil
+}
+
+// Redact hides the key material of keys in the format accepted by ParseKeys, keeping their key ID
+// and algorithm.
+func Redact(specs string) string {
+	fields := strings.Fields(specs)
+	for i, spec := range fields {
+		fields[i] = redact(spec)
+	}
+	return strings.Join(fields, " ")
+}
+
+func redact(spec string) string {
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		return spec[:i+1] + "xxxxx"
+	}
+	return "xxxxx"
+}
@@ -0,0 +1,221 @@
+package jwtauth
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	hmacSecret = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("s", MinHMACKeySize)))
+	seed       = []byte(strings.Repeat("e", ed25519.SeedSize))
+	edSeed     = base64.StdEncoding.EncodeToString(seed)
+	edPublic   = base64.StdEncoding.EncodeToString(ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey))
+)
+
+func claims(issuer string, expires time.Time) *jwt.RegisteredClaims {
+	return &jwt.RegisteredClaims{
+		Issuer:    issuer,
+		Subject:   "42",
+		ExpiresAt: jwt.NewNumericDate(expires),
+	}
+}
+
+func mustParseKeys(t *testing.T, specs []string, signingKID, issuer string) *KeySet {
+	t.Helper()
+
+	ks, err := ParseKeys(specs, signingKID, issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		specs      []string
+		signingKID string
+		wantKID    string
+		wantErr    string
+	}{
+		{name: "hs256", specs: []string{"k1=hs256:" + hmacSecret}, wantKID: "k1"},
+		{name: "ed25519 seed", specs: []string{"k1=ed25519:" + edSeed}, wantKID: "k1"},
+		{
+			name:    "ed25519 private key",
+			specs:   []string{"k1=ed25519:" + base64.StdEncoding.EncodeToString(ed25519.NewKeyFromSeed(seed))},
+			wantKID: "k1",
+		},
+		{
+			name:       "explicit signing key",
+			specs:      []string{"old=ed25519-public:" + edPublic, "new=hs256:" + hmacSecret},
+			signingKID: "new",
+			wantKID:    "new",
+		},
+		{name: "no keys", wantErr: "at least one key"},
+		{name: "missing kid", specs: []string{"hs256:" + hmacSecret}, wantErr: "format"},
+		{name: "missing algorithm", specs: []string{"k1=" + hmacSecret}, wantErr: "format"},
+		{name: "not base64", specs: []string{"k1=hs256:!!!"}, wantErr: "base64"},
+		{name: "short secret", specs: []string{"k1=hs256:c2hvcnQ="}, wantErr: "at least 32 bytes"},
+		{name: "bad ed25519 size", specs: []string{"k1=ed25519:c2hvcnQ="}, wantErr: "ed25519 private key"},
+		{name: "unknown algorithm", specs: []string{"k1=rs256:" + hmacSecret}, wantErr: "unknown algorithm"},
+		{name: "duplicate kid", specs: []string{"k1=hs256:" + hmacSecret, "k1=ed25519:" + edSeed}, wantErr: "more than once"},
+		{name: "unknown signing key", specs: []string{"k1=hs256:" + hmacSecret}, signingKID: "k2", wantErr: "not defined"},
+		{name: "public signing key", specs: []string{"k1=ed25519-public:" + edPublic}, wantErr: "only verify"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks, err := ParseKeys(tt.specs, tt.signingKID, "schedule")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ks.signingKID != tt.wantKID {
+				t.Errorf("signing key = %q, want %q", ks.signingKID, tt.wantKID)
+			}
+			if ks.Issuer() != "schedule" {
+				t.Errorf("Issuer() = %q", ks.Issuer())
+			}
+		})
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	for _, spec := range []string{"k1=hs256:" + hmacSecret, "k1=ed25519:" + edSeed} {
+		t.Run(spec[:strings.Index(spec, ":")], func(t *testing.T) {
+			ks := mustParseKeys(t, []string{spec}, "", "schedule")
+
+			token, err := ks.Sign(claims(ks.Issuer(), time.Now().Add(time.Minute)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got jwt.RegisteredClaims
+			if err := ks.Verify(token, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Subject != "42" || got.Issuer != "schedule" {
+				t.Errorf("got claims %+v", got)
+			}
+		})
+	}
+}
+
+func TestSignRequiresIssuer(t *testing.T) {
+	ks := mustParseKeys(t, []string{"k1=hs256:" + hmacSecret}, "", "schedule")
+
+	for _, issuer := range []string{"", "other"} {
+		if _, err := ks.Sign(claims(issuer, time.Now().Add(time.Minute))); err == nil {
+			t.Errorf("signed claims with issuer %q", issuer)
+		}
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	signer := mustParseKeys(t, []string{"k1=hs256:" + hmacSecret}, "", "schedule")
+	valid, err := signer.Sign(claims("schedule", time.Now().Add(time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := signer.Sign(claims("schedule", time.Now().Add(-time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noExpiry, err := signer.Sign(&jwt.RegisteredClaims{Issuer: "schedule", Subject: "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherIssuer := mustParseKeys(t, []string{"k1=hs256:" + hmacSecret}, "", "other")
+
+	otherSecret := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("x", MinHMACKeySize)))
+	otherKey := mustParseKeys(t, []string{"k1=hs256:" + otherSecret}, "", "schedule")
+	otherKID := mustParseKeys(t, []string{"k2=hs256:" + hmacSecret}, "", "schedule")
+
+	// A token signed with HMAC, using the Ed25519 public key as the secret, must not be
+	// accepted by a key set that only knows the public key.
+	public := mustParseKeys(t, []string{"k1=ed25519-public:" + edPublic, "k2=hs256:" + hmacSecret}, "k2", "schedule")
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, claims("schedule", time.Now().Add(time.Minute)))
+	confused.Header["kid"] = "k1"
+	publicKey, _ := base64.StdEncoding.DecodeString(edPublic)
+	confusedToken, err := confused.SignedString(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		ks    *KeySet
+		token string
+	}{
+		{"expired", signer, expired},
+		{"without expiry", signer, noExpiry},
+		{"other issuer", otherIssuer, valid},
+		{"bad signature", otherKey, valid},
+		{"unknown key", otherKID, valid},
+		{"algorithm confusion", public, confusedToken},
+		{"malformed", signer, "not.a.token"},
+		{"tampered", signer, valid[:len(valid)-2] + "xx"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got jwt.RegisteredClaims
+			err := tt.ks.Verify(tt.token, &got)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("error = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := mustParseKeys(t, []string{"2024=ed25519:" + edSeed}, "", "schedule")
+	token, err := old.Sign(claims("schedule", time.Now().Add(time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After the rotation, tokens are signed with the new key, and those signed with the old one
+	// are still accepted until it's removed.
+	rotated := mustParseKeys(t, []string{"2024=ed25519-public:" + edPublic, "2025=hs256:" + hmacSecret}, "2025", "schedule")
+
+	var got jwt.RegisteredClaims
+	if err := rotated.Verify(token, &got); err != nil {
+		t.Errorf("token signed with the old key rejected: %v", err)
+	}
+
+	newToken, err := rotated.Sign(claims("schedule", time.Now().Add(time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header["kid"] != "2025" {
+		t.Errorf("kid = %v, want 2025", parsed.Header["kid"])
+	}
+}
+
+func TestRedact(t *testing.T) {
+	got := Redact("k1=hs256:" + hmacSecret + "  k2=ed25519-public:" + edPublic + " garbage")
+	want := "k1=hs256:xxxxx k2=ed25519-public:xxxxx xxxxx"
+	if got != want {
+		t.Errorf("Redact() = %q, want %q", got, want)
+	}
+}
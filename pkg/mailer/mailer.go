@@ -0,0 +1,138 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"time"
+
+	texttemplate "text/template"
+)
+
+// templateFS holds the email templates. Every template defines three named templates: "subject",
+// "plainBody" and "htmlBody".
+//
+//go:embed "templates"
+var templateFS embed.FS
+
+// Message is a rendered email, ready to be handed to a Sender.
+type Message struct {
+	From      string
+	To        string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// Sender delivers rendered messages. SMTPSender delivers them to an SMTP server, and
+// WriterSender writes them to a file or stdout for development.
+type Sender interface {
+	Send(msg *Message) error
+}
+
+// Mailer renders the email templates and hands the result to its Sender.
+type Mailer struct {
+	sender Sender
+	from   string
+}
+
+// New returns a Mailer that sends emails from the provided sender address through sender.
+func New(sender Sender, from string) Mailer {
+	return Mailer{
+		sender: sender,
+		from:   from,
+	}
+}
+
+// Send renders the templateFile template with the provided dynamic data and sends the result to
+// the recipient.
+func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
+	// The subject and plain-text body are rendered with text/template, so that they aren't
+	// HTML-escaped. The HTML body is rendered with html/template.
+	textTmpl, err := texttemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return err
+	}
+
+	subject := new(bytes.Buffer)
+	err = textTmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = textTmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return err
+	}
+
+	htmlTmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return err
+	}
+
+	msg := &Message{
+		From:      m.from,
+		To:        recipient,
+		Subject:   strings.TrimSpace(subject.String()),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}
+
+	return m.sender.Send(msg)
+}
+
+// Bytes formats the message as a multipart/alternative MIME email with a plain-text and an HTML
+// part.
+func (msg *Message) Bytes() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+
+	fmt.Fprintf(buf, "From: %s\r\n", msg.From)
+	fmt.Fprintf(buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", mw.Boundary())
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", msg.PlainBody},
+		{"text/html; charset=utf-8", msg.HTMLBody},
+	}
+
+	for _, part := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = pw.Write([]byte(part.body))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := mw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,188 @@
+package mailer
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// recordingSender keeps the messages it's asked to send.
+type recordingSender struct {
+	messages []*Message
+}
+
+func (s *recordingSender) Send(msg *Message) error {
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+func TestMailerSend(t *testing.T) {
+	tests := []struct {
+		name        string
+		template    string
+		data        map[string]interface{}
+		wantSubject string
+		wantPlain   []string
+		wantHTML    []string
+	}{
+		{
+			name:     "welcome",
+			template: "user_welcome.tmpl",
+			data: map[string]interface{}{
+				"name":            "Ada <admin>",
+				"userID":          42,
+				"activationToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+			},
+			wantSubject: "Welcome to Schedule!",
+			// The plain-text body isn't HTML-escaped, the HTML body is.
+			wantPlain: []string{"Hi Ada <admin>,", "user ID number is 42", `{"token": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"}`},
+			wantHTML:  []string{"Hi Ada &lt;admin&gt;,", "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"},
+		},
+		{
+			name:     "password reset",
+			template: "password_reset.tmpl",
+			data: map[string]interface{}{
+				"passwordResetToken": "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+			},
+			wantPlain: []string{"ABCDEFGHIJKLMNOPQRSTUVWXYZ"},
+			wantHTML:  []string{"ABCDEFGHIJKLMNOPQRSTUVWXYZ"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := &recordingSender{}
+			m := New(sender, "Schedule <no-reply@schedule.local>")
+
+			err := m.Send("ada@example.com", tt.template, tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(sender.messages) != 1 {
+				t.Fatalf("sent %d messages, want 1", len(sender.messages))
+			}
+			msg := sender.messages[0]
+
+			if msg.From != "Schedule <no-reply@schedule.local>" || msg.To != "ada@example.com" {
+				t.Errorf("From = %q, To = %q", msg.From, msg.To)
+			}
+			if msg.Subject == "" || strings.TrimSpace(msg.Subject) != msg.Subject {
+				t.Errorf("Subject = %q, want a trimmed, non-empty subject", msg.Subject)
+			}
+			if tt.wantSubject != "" && msg.Subject != tt.wantSubject {
+				t.Errorf("Subject = %q, want %q", msg.Subject, tt.wantSubject)
+			}
+			for _, want := range tt.wantPlain {
+				if !strings.Contains(msg.PlainBody, want) {
+					t.Errorf("plain body doesn't contain %q:\n%s", want, msg.PlainBody)
+				}
+			}
+			for _, want := range tt.wantHTML {
+				if !strings.Contains(msg.HTMLBody, want) {
+					t.Errorf("HTML body doesn't contain %q:\n%s", want, msg.HTMLBody)
+				}
+			}
+		})
+	}
+}
+
+func TestMailerSendUnknownTemplate(t *testing.T) {
+	sender := &recordingSender{}
+
+	err := New(sender, "no-reply@schedule.local").Send("ada@example.com", "missing.tmpl", nil)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(sender.messages) != 0 {
+		t.Errorf("sent %d messages, want none", len(sender.messages))
+	}
+}
+
+func TestMessageBytes(t *testing.T) {
+	msg := &Message{
+		From:      "Schedule <no-reply@schedule.local>",
+		To:        "ada@example.com",
+		Subject:   "Сброс пароля",
+		PlainBody: "plain body",
+		HTMLBody:  "<p>html body</p>",
+	}
+
+	raw, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != msg.Subject {
+		t.Errorf("Subject = %q, want %q", subject, msg.Subject)
+	}
+	if parsed.Header.Get("To") != msg.To || parsed.Header.Get("From") != msg.From {
+		t.Errorf("From = %q, To = %q", parsed.Header.Get("From"), parsed.Header.Get("To"))
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, %v", parsed.Header.Get("Content-Type"), err)
+	}
+
+	parts := []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.PlainBody},
+		{"text/html; charset=utf-8", msg.HTMLBody},
+	}
+
+	mr := multipart.NewReader(parsed.Body, params["boundary"])
+	for _, want := range parts {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if part.Header.Get("Content-Type") != want.contentType || string(body) != want.body {
+			t.Errorf("part = %q %q, want %q %q", part.Header.Get("Content-Type"), body, want.contentType, want.body)
+		}
+	}
+
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("expected exactly two parts, got %v", err)
+	}
+}
+
+func TestWriterSender(t *testing.T) {
+	var buf bytes.Buffer
+	sender := NewWriterSender(&buf)
+
+	for _, to := range []string{"ada@example.com", "alan@example.com"} {
+		err := sender.Send(&Message{From: "no-reply@schedule.local", To: to, Subject: "Hi"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := buf.String()
+	if got := strings.Count(out, "----- end of message -----"); got != 2 {
+		t.Errorf("got %d message separators, want 2", got)
+	}
+	for _, to := range []string{"To: ada@example.com", "To: alan@example.com"} {
+		if !strings.Contains(out, to) {
+			t.Errorf("output doesn't contain %q", to)
+		}
+	}
+}
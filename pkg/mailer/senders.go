@@ -0,0 +1,148 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SMTPSender delivers messages to an SMTP server, upgrading the connection with STARTTLS when the
+// server supports it.
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// Timeout bounds the whole exchange with the server.
+	Timeout time.Duration
+}
+
+// Send delivers the message to the SMTP server.
+func (s *SMTPSender) Send(msg *Message) error {
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+
+	conn, err := net.DialTimeout("tcp", addr, s.Timeout)
+	if err != nil {
+		return err
+	}
+	// The smtp package has no timeouts of its own, so put a deadline on the connection.
+	err = conn.SetDeadline(time.Now().Add(s.Timeout))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: s.Host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	// The envelope addresses must be bare addresses, without a display name.
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return err
+	}
+
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+
+	err = client.Mail(from.Address)
+	if err != nil {
+		return err
+	}
+
+	err = client.Rcpt(to.Address)
+	if err != nil {
+		return err
+	}
+
+	wc, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = wc.Write(body)
+	if err != nil {
+		return err
+	}
+
+	err = wc.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// WriterSender writes messages to an io.Writer instead of delivering them. It's meant for
+// development, where it's handy to see the emails the application would send.
+type WriterSender struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewWriterSender returns a WriterSender that writes every message to out.
+func NewWriterSender(out io.Writer) *WriterSender {
+	return &WriterSender{out: out}
+}
+
+// NewFileSender returns a WriterSender that appends every message to the named file, creating the
+// file and its directory if needed.
+func NewFileSender(path string) (*WriterSender, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWriterSender(f), nil
+}
+
+// Send writes the message, followed by a separator line.
+func (s *WriterSender) Send(msg *Message) error {
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = fmt.Fprintf(s.out, "%s\r\n----- end of message -----\r\n\r\n", body)
+	return err
+}
@@ -0,0 +1,292 @@
+// Package metrics implements the few metric types the API needs and writes them in the
+// Prometheus text exposition format, so that the service can be scraped without pulling in the
+// Prometheus client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the histogram buckets used for request
+// latencies.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds the metrics exposed by a Handler.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes every metric of the registry, in the order they were registered, to w.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+
+	return bw.Flush()
+}
+
+// Handler returns an http.Handler serving the metrics of the registry.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.Write(w)
+	})
+}
+
+// vec holds the values of a metric for every combination of label values.
+type vec[T any] struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series[T]
+	init   func() T
+}
+
+type series[T any] struct {
+	labels []string
+	value  T
+}
+
+func newVec[T any](name, help, typ string, labels []string, init func() T) *vec[T] {
+	return &vec[T]{name: name, help: help, typ: typ, labels: labels, series: make(map[string]*series[T]), init: init}
+}
+
+// with calls fn with the value of the series identified by the label values. The caller must not
+// hold v.mu.
+func (v *vec[T]) with(values []string, fn func(value *T)) {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	s, ok := v.series[key]
+	if !ok {
+		s = &series[T]{labels: append([]string(nil), values...), value: v.init()}
+		v.series[key] = s
+	}
+	fn(&s.value)
+}
+
+// each calls fn for every series, ordered by label values. The caller must hold v.mu.
+func (v *vec[T]) each(fn func(labels []string, value T)) {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fn(v.series[key].labels, v.series[key].value)
+	}
+}
+
+func (v *vec[T]) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.typ)
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	*vec[float64]
+}
+
+// NewCounterVec registers a counter with the given label names.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", labels, func() float64 { return 0 })}
+	r.register(c)
+	return c
+}
+
+// Inc increments the counter identified by the label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.with(values, func(value *float64) { *value++ })
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header(w)
+	c.each(func(values []string, value float64) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, values), formatValue(value))
+	})
+}
+
+// GaugeVec is a gauge partitioned by labels. A GaugeVec without labels is a plain gauge.
+type GaugeVec struct {
+	*vec[float64]
+}
+
+// NewGaugeVec registers a gauge with the given label names.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, "gauge", labels, func() float64 { return 0 })}
+	r.register(g)
+	return g
+}
+
+// Set sets the gauge identified by the label values.
+func (g *GaugeVec) Set(v float64, values ...string) {
+	g.with(values, func(value *float64) { *value = v })
+}
+
+// Add adds v, which may be negative, to the gauge identified by the label values.
+func (g *GaugeVec) Add(v float64, values ...string) {
+	g.with(values, func(value *float64) { *value += v })
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.header(w)
+	g.each(func(values []string, value float64) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, values), formatValue(value))
+	})
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	*vec[*histogram]
+	buckets []float64
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec registers a histogram with the given bucket upper bounds, which must be sorted
+// in ascending order, and label names.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{buckets: buckets}
+	h.vec = newVec(name, help, "histogram", labels, func() *histogram {
+		return &histogram{counts: make([]uint64, len(buckets))}
+	})
+	r.register(h)
+	return h
+}
+
+// Observe adds v to the histogram identified by the label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	h.with(values, func(value **histogram) {
+		hist := *value
+		for i, bound := range h.buckets {
+			if v <= bound {
+				hist.counts[i]++
+			}
+		}
+		hist.sum += v
+		hist.count++
+	})
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.header(w)
+	h.each(func(values []string, hist *histogram) {
+		labels := append(append([]string(nil), h.labels...), "le")
+		for i, bound := range h.buckets {
+			le := append(append([]string(nil), values...), formatValue(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, le), hist.counts[i])
+		}
+		inf := append(append([]string(nil), values...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, inf), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values), formatValue(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values), hist.count)
+	})
+}
+
+// funcMetric is a metric without labels whose value is computed by fn when it's written.
+type funcMetric struct {
+	name string
+	help string
+	typ  string
+	fn   func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is returned by fn.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{name: name, help: help, typ: "gauge", fn: fn})
+}
+
+// NewCounterFunc registers a counter whose value is returned by fn. The value must never
+// decrease.
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{name: name, help: help, typ: "counter", fn: fn})
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.typ)
+	fmt.Fprintf(w, "%s %s\n", f.name, formatValue(f.fn()))
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabel(values[i]) + `"`
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		register func(r *Registry)
+		want     string
+	}{
+		{
+			name: "counter",
+			register: func(r *Registry) {
+				c := r.NewCounterVec("requests_total", "Number of requests.", "method", "code")
+				c.Inc("GET", "200")
+				c.Inc("GET", "200")
+				c.Inc("DELETE", "404")
+			},
+			want: "# HELP requests_total Number of requests.\n# TYPE requests_total counter\n" +
+				"requests_total{method=\"DELETE\",code=\"404\"} 1\n" +
+				"requests_total{method=\"GET\",code=\"200\"} 2\n",
+		},
+		{
+			name: "gauge without labels",
+			register: func(r *Registry) {
+				g := r.NewGaugeVec("in_flight", "Requests in flight.")
+				g.Add(3)
+				g.Add(-1)
+			},
+			want: "# HELP in_flight Requests in flight.\n# TYPE in_flight gauge\nin_flight 2\n",
+		},
+		{
+			name: "gauge set",
+			register: func(r *Registry) {
+				r.NewGaugeVec("build_info", "Build information.", "version").Set(1, "v1.0.0")
+			},
+			want: "# HELP build_info Build information.\n# TYPE build_info gauge\nbuild_info{version=\"v1.0.0\"} 1\n",
+		},
+		{
+			name: "histogram",
+			register: func(r *Registry) {
+				h := r.NewHistogramVec("duration_seconds", "Latency.", []float64{0.1, 1}, "route")
+				h.Observe(0.05, "/a")
+				h.Observe(0.5, "/a")
+				h.Observe(2, "/a")
+			},
+			want: "# HELP duration_seconds Latency.\n# TYPE duration_seconds histogram\n" +
+				"duration_seconds_bucket{route=\"/a\",le=\"0.1\"} 1\n" +
+				"duration_seconds_bucket{route=\"/a\",le=\"1\"} 2\n" +
+				"duration_seconds_bucket{route=\"/a\",le=\"+Inf\"} 3\n" +
+				"duration_seconds_sum{route=\"/a\"} 2.55\n" +
+				"duration_seconds_count{route=\"/a\"} 3\n",
+		},
+		{
+			name: "funcs",
+			register: func(r *Registry) {
+				r.NewGaugeFunc("open_connections", "Open connections.", func() float64 { return 4 })
+				r.NewCounterFunc("wait_seconds_total", "Time waited.", func() float64 { return 1.5 })
+			},
+			want: "# HELP open_connections Open connections.\n# TYPE open_connections gauge\nopen_connections 4\n" +
+				"# HELP wait_seconds_total Time waited.\n# TYPE wait_seconds_total counter\nwait_seconds_total 1.5\n",
+		},
+		{
+			name: "escaping",
+			register: func(r *Registry) {
+				r.NewCounterVec("escaped_total", "Help with a \\ and a\nnewline.", "value").Inc("a \"quoted\" \\ value\n")
+			},
+			want: "# HELP escaped_total Help with a \\\\ and a\\nnewline.\n# TYPE escaped_total counter\n" +
+				"escaped_total{value=\"a \\\"quoted\\\" \\\\ value\\n\"} 1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			tt.register(r)
+
+			var b strings.Builder
+			if err := r.Write(&b); err != nil {
+				t.Fatal(err)
+			}
+
+			if b.String() != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{0, "0"},
+		{42, "42"},
+		{0.005, "0.005"},
+		{1e21, "1e+21"},
+	}
+
+	for _, tt := range tests {
+		if got := formatValue(tt.value); got != tt.want {
+			t.Errorf("formatValue(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestWrongNumberOfLabels(t *testing.T) {
+	c := NewRegistry().NewCounterVec("requests_total", "Number of requests.", "method", "code")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+
+	c.Inc("GET")
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeVec("up", "Whether the service is up.").Set(1)
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(w.Body.String(), "\nup 1\n") {
+		t.Errorf("body doesn't contain the gauge:\n%s", w.Body.String())
+	}
+}
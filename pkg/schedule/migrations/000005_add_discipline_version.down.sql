@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code = 'disciplines:write';
+ALTER TABLE discipline DROP COLUMN IF EXISTS version;
@@ -0,0 +1,4 @@
+ALTER TABLE discipline ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+INSERT INTO permissions (code)
+VALUES ('disciplines:write');
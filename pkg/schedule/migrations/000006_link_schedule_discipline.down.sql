@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS discipline_schedule_discipline_idx;
+ALTER TABLE discipline_schedule DROP CONSTRAINT IF EXISTS discipline_schedule_schedule_key;
@@ -0,0 +1,5 @@
+-- Every schedule slot is taught for exactly one discipline.
+ALTER TABLE discipline_schedule
+    ADD CONSTRAINT discipline_schedule_schedule_key UNIQUE (schedule);
+
+CREATE INDEX IF NOT EXISTS discipline_schedule_discipline_idx ON discipline_schedule (discipline);
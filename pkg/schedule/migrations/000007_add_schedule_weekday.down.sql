@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS schedule_slot_idx;
+ALTER TABLE schedule
+    DROP COLUMN IF EXISTS week_parity,
+    DROP COLUMN IF EXISTS day_of_week;
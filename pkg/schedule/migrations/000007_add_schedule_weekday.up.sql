@@ -0,0 +1,7 @@
+-- day_of_week follows ISO 8601: 1 is Monday and 7 is Sunday. An empty week_parity means the
+-- class takes place every week.
+ALTER TABLE schedule
+    ADD COLUMN IF NOT EXISTS day_of_week int  NOT NULL DEFAULT 1 CHECK (day_of_week BETWEEN 1 AND 7),
+    ADD COLUMN IF NOT EXISTS week_parity text NOT NULL DEFAULT '' CHECK (week_parity IN ('', 'odd', 'even'));
+
+CREATE INDEX IF NOT EXISTS schedule_slot_idx ON schedule (day_of_week, time_period);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS tokens_user_scope_idx;
+ALTER TABLE tokens
+    DROP COLUMN IF EXISTS user_agent,
+    DROP COLUMN IF EXISTS created_at,
+    DROP COLUMN IF EXISTS id;
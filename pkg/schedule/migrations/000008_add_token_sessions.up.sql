@@ -0,0 +1,8 @@
+-- Authentication tokens double as sessions: give them an id that can be shown to the user
+-- without revealing the hash, and record when and from which client they were created.
+ALTER TABLE tokens
+    ADD COLUMN IF NOT EXISTS id         BIGSERIAL UNIQUE,
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    ADD COLUMN IF NOT EXISTS user_agent TEXT                        NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS tokens_user_scope_idx ON tokens (user_id, scope);
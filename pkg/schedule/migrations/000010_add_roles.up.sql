@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS roles
+(
+	id   BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions
+(
+	role_id       BIGINT NOT NULL REFERENCES roles ON DELETE CASCADE,
+	permission_id BIGINT NOT NULL REFERENCES permissions ON DELETE CASCADE,
+	PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles
+(
+	user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+	role_id BIGINT NOT NULL REFERENCES roles ON DELETE CASCADE,
+	PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO roles (name)
+VALUES ('student'),
+			 ('teacher'),
+			 ('dean'),
+			 ('registrar'),
+			 ('admin');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles
+	INNER JOIN permissions ON
+		(roles.name IN ('student', 'teacher') AND permissions.code = 'schedules:read')
+		OR (roles.name IN ('dean', 'registrar')
+			AND permissions.code IN ('schedules:read', 'schedules:write', 'disciplines:write'))
+		OR roles.name = 'admin';
@@ -0,0 +1,10 @@
+-- A login issues an authentication token and a refresh token that belong to the same family.
+-- Every refresh rotates the refresh token: the used one is kept, marked as rotated, so that its
+-- reuse can be detected and the whole family revoked.
+CREATE SEQUENCE IF NOT EXISTS tokens_family_seq;
+
+ALTER TABLE tokens
+    ADD COLUMN IF NOT EXISTS family_id  BIGINT,
+    ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP(0) WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens (family_id);
@@ -0,0 +1,4 @@
+DELETE FROM tokens WHERE scope = 'two-factor';
+
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS totp;
@@ -0,0 +1,19 @@
+-- The TOTP secret of a user. It's only enforced at login once confirmed_at is set, after the user
+-- proved their authenticator app works. last_step is the time step of the last code accepted,
+-- so that a code can't be used twice.
+CREATE TABLE IF NOT EXISTS totp
+(
+    user_id      BIGINT PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    secret       BYTEA                       NOT NULL,
+    confirmed_at TIMESTAMP(0) WITH TIME ZONE,
+    last_step    BIGINT                      NOT NULL DEFAULT 0
+);
+
+-- Single-use codes that stand in for a TOTP code when the authenticator app is lost. Only their
+-- SHA-256 hash is stored, and a code is deleted once used.
+CREATE TABLE IF NOT EXISTS recovery_codes
+(
+    user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    hash    BYTEA  NOT NULL,
+    PRIMARY KEY (user_id, hash)
+);
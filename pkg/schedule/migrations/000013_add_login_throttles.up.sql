@@ -0,0 +1,12 @@
+-- Failed logins, counted per email address and per client IP address. The key is "email:<email>"
+-- or "ip:<address>". Logins with the key are refused until blocked_until, which grows with the
+-- number of failures, and failures are forgotten some time after the last one.
+CREATE TABLE IF NOT EXISTS login_throttles
+(
+    key           TEXT PRIMARY KEY,
+    failures      INTEGER                     NOT NULL,
+    last_failure  TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    blocked_until TIMESTAMP(0) WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS login_throttles_last_failure_idx ON login_throttles (last_failure);
@@ -0,0 +1,28 @@
+-- Long-lived keys for services calling the API on behalf of a user. Only the SHA-256 hash of a
+-- key is stored, along with its first characters so that users can tell their keys apart.
+-- created_by is the user who created the key, which is the owner unless an administrator
+-- created it for them. An empty allowed_ips accepts requests from any address.
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      BIGINT                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_by   BIGINT                      REFERENCES users ON DELETE SET NULL,
+    name         TEXT                        NOT NULL,
+    prefix       TEXT                        NOT NULL,
+    hash         BYTEA                       NOT NULL UNIQUE,
+    allowed_ips  CIDR[]                      NOT NULL DEFAULT '{}',
+    expiry       TIMESTAMP(0) WITH TIME ZONE,
+    created_at   TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP(0) WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
+
+-- The permission codes a key is restricted to. A key can only use the codes its owner still
+-- has, so they are checked against the owner's permissions on every request.
+CREATE TABLE IF NOT EXISTS api_keys_permissions
+(
+    api_key_id    BIGINT NOT NULL REFERENCES api_keys ON DELETE CASCADE,
+    permission_id BIGINT NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (api_key_id, permission_id)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE totp
+    DROP COLUMN IF EXISTS failed_attempts;
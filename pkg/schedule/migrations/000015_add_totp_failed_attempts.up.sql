@@ -0,0 +1,5 @@
+-- Codes rejected when disabling two-factor authentication since the last accepted one. Once
+-- there are too many, the user's sessions are revoked and disabling is refused until a code is
+-- accepted at login.
+ALTER TABLE totp
+    ADD COLUMN IF NOT EXISTS failed_attempts INTEGER NOT NULL DEFAULT 0;
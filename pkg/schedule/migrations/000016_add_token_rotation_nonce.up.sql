@@ -0,0 +1,5 @@
+-- The pair a refresh token is exchanged for is derived from it with a random nonce, kept on the
+-- rotated refresh token, so that a retry within the grace period can be given the same pair while
+-- the refresh token alone isn't enough to compute it.
+ALTER TABLE tokens
+    ADD COLUMN IF NOT EXISTS rotation_nonce BYTEA;
@@ -0,0 +1,94 @@
+// Package migrations embeds the SQL migrations of the database schema, so that the binary knows
+// which schema version it expects and can apply the migrations itself.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// LockTimeout is how long New's migrator waits for the advisory lock held by another instance
+// that is migrating the same database.
+const LockTimeout = 5 * time.Minute
+
+// FS holds the migration files, named NNNNNN_description.up.sql and NNNNNN_description.down.sql
+// as expected by golang-migrate.
+//
+//go:embed *.sql
+var FS embed.FS
+
+// Latest returns the version of the newest migration, which is the schema version the
+// application expects the database to be at.
+func Latest() (uint, error) {
+	entries, err := fs.ReadDir(FS, ".")
+	if err != nil {
+		return 0, err
+	}
+
+	var latest uint
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".up.sql") {
+			continue
+		}
+
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok {
+			return 0, fmt.Errorf("migration %q must be named NNNNNN_description.up.sql", name)
+		}
+
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("migration %q must start with its version number", name)
+		}
+
+		if uint(version) > latest {
+			latest = uint(version)
+		}
+	}
+
+	return latest, nil
+}
+
+// New returns a migrator applying the embedded migrations to db. Every operation of the migrator
+// takes a PostgreSQL advisory lock first, so that several instances started at once apply each
+// migration only once.
+//
+// The migrator runs on a connection of its own, taken from the pool, so closing the migrator
+// returns that connection without closing db.
+func New(ctx context.Context, db *sql.DB) (*migrate.Migrate, error) {
+	source, err := iofs.New(FS, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		driver.Close()
+		return nil, err
+	}
+	m.LockTimeout = LockTimeout
+
+	return m, nil
+}
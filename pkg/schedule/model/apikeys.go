@@ -0,0 +1,246 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/21b030939/golang-project/pkg/schedule/validator"
+)
+
+const (
+	// apiKeyPrefix starts every API key, which makes keys easy to recognize, for instance by
+	// secret scanners, and tells them apart from authentication tokens.
+	apiKeyPrefix = "sch_"
+	// apiKeyLength is the length of an API key: the prefix and 32 random bytes in base-32.
+	apiKeyLength = len(apiKeyPrefix) + 52
+	// apiKeyDisplayLength is the number of characters of a key stored in plaintext and shown
+	// in listings, so that users can tell their keys apart.
+	apiKeyDisplayLength = len(apiKeyPrefix) + 6
+)
+
+// APIKey is a long-lived credential a service uses to call the API on behalf of a user, its
+// owner. It's restricted to a subset of the owner's permission codes, and optionally to a set of
+// client addresses and an expiry.
+type APIKey struct {
+	ID     int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+	// CreatedBy is the user who created the key, or zero if that user was deleted.
+	CreatedBy int64  `json:"created_by"`
+	Name      string `json:"name"`
+	// Plaintext is only set when the key is created, it can't be retrieved afterwards.
+	Plaintext   string      `json:"key,omitempty"`
+	Prefix      string      `json:"prefix"`
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	// AllowedIPs holds the addresses and networks, in CIDR notation, the key may be used from.
+	// The key may be used from anywhere if it's empty.
+	AllowedIPs []string   `json:"allowed_ips"`
+	Expiry     *time.Time `json:"expiry"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type APIKeyModel struct {
+	DB       *sqlx.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Timeout  time.Duration
+}
+
+// Insert generates a new key and stores it along with its permission codes. The ID, plaintext,
+// prefix, hash and creation time of the key are set.
+func (m APIKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	key.Plaintext = apiKeyPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	key.Prefix = key.Plaintext[:apiKeyDisplayLength]
+	key.Hash = HashTokenPlaintext(key.Plaintext)
+
+	if key.AllowedIPs == nil {
+		key.AllowedIPs = []string{}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO api_keys (user_id, created_by, name, prefix, hash, allowed_ips, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6::cidr[], $7)
+		RETURNING id, created_at
+		`
+
+	args := []interface{}{key.UserID, key.CreatedBy, key.Name, key.Prefix, key.Hash,
+		pq.Array(key.AllowedIPs), key.Expiry}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO api_keys_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		`
+
+	_, err = tx.ExecContext(ctx, query, key.ID, pq.Array(key.Permissions))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// apiKeySelect selects the columns scanned by scanAPIKey, the permission codes of a key being
+// aggregated into an array.
+const apiKeySelect = `
+	SELECT api_keys.id, api_keys.user_id, COALESCE(api_keys.created_by, 0), api_keys.name,
+		api_keys.prefix, api_keys.hash, api_keys.allowed_ips::text[], api_keys.expiry,
+		api_keys.created_at, api_keys.last_used_at,
+		COALESCE(array_agg(permissions.code ORDER BY permissions.code)
+			FILTER (WHERE permissions.code IS NOT NULL), '{}')
+	FROM api_keys
+		LEFT JOIN api_keys_permissions ON api_keys_permissions.api_key_id = api_keys.id
+		LEFT JOIN permissions ON permissions.id = api_keys_permissions.permission_id
+	`
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var (
+		key        APIKey
+		expiry     sql.NullTime
+		lastUsedAt sql.NullTime
+	)
+
+	err := row.Scan(&key.ID, &key.UserID, &key.CreatedBy, &key.Name, &key.Prefix, &key.Hash,
+		(*pq.StringArray)(&key.AllowedIPs), &expiry, &key.CreatedAt, &lastUsedAt,
+		(*pq.StringArray)(&key.Permissions))
+	if err != nil {
+		return nil, err
+	}
+
+	if expiry.Valid {
+		key.Expiry = &expiry.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return &key, nil
+}
+
+// GetForKey returns the unexpired API key matching a plaintext key. It returns
+// ErrRecordNotFound if there is no such key.
+func (m APIKeyModel) GetForKey(ctx context.Context, plaintext string) (*APIKey, error) {
+	query := apiKeySelect + `
+		WHERE api_keys.hash = $1 AND (api_keys.expiry IS NULL OR api_keys.expiry > $2)
+		GROUP BY api_keys.id
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	key, err := scanAPIKey(m.DB.QueryRowContext(ctx, query, HashTokenPlaintext(plaintext), time.Now()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// GetAllForUser returns every API key of a user, expired ones included, newest first.
+func (m APIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	query := apiKeySelect + `
+		WHERE api_keys.user_id = $1
+		GROUP BY api_keys.id
+		ORDER BY api_keys.created_at DESC, api_keys.id DESC
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Touch records that an API key was just used.
+func (m APIKeyModel) Touch(ctx context.Context, id int64) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE id = $1
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// DeleteForUser revokes an API key of a specific user by its id. It returns ErrRecordNotFound
+// if the user has no such key.
+func (m APIKeyModel) DeleteForUser(ctx context.Context, userID, id int64) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return expectRows(m.DB.ExecContext(ctx, query, id, userID))
+}
+
+// ValidateAPIKeyPlaintext checks that a key has the format of the keys generated by Insert.
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(plaintext != "", "key", "must be provided")
+	v.Check(strings.HasPrefix(plaintext, apiKeyPrefix), "key", "must start with "+apiKeyPrefix)
+	v.Check(len(plaintext) == apiKeyLength, "key", "must be 56 bytes long")
+}
@@ -0,0 +1,141 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBellSchedule is the bell schedule used when none is configured: eight 50-minute
+// periods starting at 08:00 with a 10-minute break between them.
+const DefaultBellSchedule = "1=08:00-08:50,2=09:00-09:50,3=10:00-10:50,4=11:00-11:50," +
+	"5=12:00-12:50,6=13:00-13:50,7=14:00-14:50,8=15:00-15:50"
+
+// Week parity values. A schedule with an empty parity takes place every week.
+const (
+	ParityOdd  = "odd"
+	ParityEven = "even"
+)
+
+// Bell holds the clock times at which a time period starts and ends, in "15:04" format.
+type Bell struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// StartOffset returns the start time as an offset from midnight.
+func (b Bell) StartOffset() time.Duration {
+	return clockOffset(b.Start)
+}
+
+// EndOffset returns the end time as an offset from midnight.
+func (b Bell) EndOffset() time.Duration {
+	return clockOffset(b.End)
+}
+
+// BellSchedule maps a time period number to the clock times of that period.
+type BellSchedule map[int]Bell
+
+// ParseBellSchedule parses a bell schedule in the "period=start-end,..." format, for example
+// "1=08:00-08:50,2=09:00-09:50". Periods must start at 1, be contiguous and must not overlap.
+func ParseBellSchedule(s string) (BellSchedule, error) {
+	bells := make(BellSchedule)
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		period, times, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("bell schedule entry %q must be in period=start-end format", entry)
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(period))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("bell schedule entry %q must have a positive period number", entry)
+		}
+
+		start, end, ok := strings.Cut(times, "-")
+		if !ok {
+			return nil, fmt.Errorf("bell schedule entry %q must be in period=start-end format", entry)
+		}
+
+		bell := Bell{Start: strings.TrimSpace(start), End: strings.TrimSpace(end)}
+		for _, clock := range []string{bell.Start, bell.End} {
+			if _, err := time.Parse("15:04", clock); err != nil {
+				return nil, fmt.Errorf("bell schedule entry %q has invalid time %q", entry, clock)
+			}
+		}
+		if bell.StartOffset() >= bell.EndOffset() {
+			return nil, fmt.Errorf("bell schedule entry %q must end after it starts", entry)
+		}
+
+		if _, exists := bells[n]; exists {
+			return nil, fmt.Errorf("bell schedule period %d is defined more than once", n)
+		}
+		bells[n] = bell
+	}
+
+	if len(bells) == 0 {
+		return nil, fmt.Errorf("bell schedule must define at least one period")
+	}
+
+	periods := bells.Periods()
+	for i, period := range periods {
+		if period != i+1 {
+			return nil, fmt.Errorf("bell schedule periods must be numbered 1 to %d without gaps", len(periods))
+		}
+		if i > 0 && bells[period].StartOffset() < bells[periods[i-1]].EndOffset() {
+			return nil, fmt.Errorf("bell schedule period %d overlaps period %d", period, periods[i-1])
+		}
+	}
+
+	return bells, nil
+}
+
+// Periods returns the period numbers of the bell schedule in ascending order.
+func (b BellSchedule) Periods() []int {
+	periods := make([]int, 0, len(b))
+	for period := range b {
+		periods = append(periods, period)
+	}
+	sort.Ints(periods)
+
+	return periods
+}
+
+// Apply fills in the start and end times of each schedule from its time period. Schedules whose
+// period is not part of the bell schedule are left without times.
+func (b BellSchedule) Apply(schedules ...*Schedule) {
+	for _, schedule := range schedules {
+		bell, ok := b[schedule.TimePeriod]
+		if !ok {
+			schedule.StartTime, schedule.EndTime = "", ""
+			continue
+		}
+		schedule.StartTime, schedule.EndTime = bell.Start, bell.End
+	}
+}
+
+// String formats the bell schedule in the format accepted by ParseBellSchedule.
+func (b BellSchedule) String() string {
+	entries := make([]string, 0, len(b))
+	for _, period := range b.Periods() {
+		entries = append(entries, fmt.Sprintf("%d=%s-%s", period, b[period].Start, b[period].End))
+	}
+
+	return strings.Join(entries, ",")
+}
+
+func clockOffset(clock string) time.Duration {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+}
@@ -0,0 +1,123 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseBellSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    BellSchedule
+		wantErr bool
+	}{
+		{
+			name:  "single period",
+			input: "1=08:00-08:50",
+			want:  BellSchedule{1: {Start: "08:00", End: "08:50"}},
+		},
+		{
+			name:  "spaces and trailing comma",
+			input: " 1 = 08:00 - 08:50 , 2=09:00-09:50, ",
+			want: BellSchedule{
+				1: {Start: "08:00", End: "08:50"},
+				2: {Start: "09:00", End: "09:50"},
+			},
+		},
+		{
+			name:  "out of order",
+			input: "2=09:00-09:50,1=08:00-08:50",
+			want: BellSchedule{
+				1: {Start: "08:00", End: "08:50"},
+				2: {Start: "09:00", End: "09:50"},
+			},
+		},
+		{name: "back to back", input: "1=08:00-08:50,2=08:50-09:40", want: BellSchedule{
+			1: {Start: "08:00", End: "08:50"},
+			2: {Start: "08:50", End: "09:40"},
+		}},
+		{name: "empty", input: "", wantErr: true},
+		{name: "missing equals", input: "1 08:00-08:50", wantErr: true},
+		{name: "missing dash", input: "1=08:00", wantErr: true},
+		{name: "zero period", input: "0=08:00-08:50", wantErr: true},
+		{name: "non-numeric period", input: "first=08:00-08:50", wantErr: true},
+		{name: "invalid time", input: "1=8am-08:50", wantErr: true},
+		{name: "out of range time", input: "1=24:00-24:50", wantErr: true},
+		{name: "ends before it starts", input: "1=08:50-08:00", wantErr: true},
+		{name: "empty period", input: "1=08:00-08:00", wantErr: true},
+		{name: "duplicate period", input: "1=08:00-08:50,1=09:00-09:50", wantErr: true},
+		{name: "gap", input: "1=08:00-08:50,3=10:00-10:50", wantErr: true},
+		{name: "not starting at 1", input: "2=08:00-08:50", wantErr: true},
+		{name: "overlap", input: "1=08:00-08:50,2=08:45-09:35", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseBellSchedule(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultBellSchedule(t *testing.T) {
+	bells, err := ParseBellSchedule(DefaultBellSchedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := bells.String(); got != DefaultBellSchedule {
+		t.Errorf("String() = %q, want %q", got, DefaultBellSchedule)
+	}
+
+	if got := len(bells.Periods()); got != 8 {
+		t.Errorf("got %d periods, want 8", got)
+	}
+}
+
+func TestBellOffsets(t *testing.T) {
+	bell := Bell{Start: "08:05", End: "13:50"}
+
+	if got, want := bell.StartOffset(), 8*time.Hour+5*time.Minute; got != want {
+		t.Errorf("StartOffset() = %v, want %v", got, want)
+	}
+	if got, want := bell.EndOffset(), 13*time.Hour+50*time.Minute; got != want {
+		t.Errorf("EndOffset() = %v, want %v", got, want)
+	}
+}
+
+func TestBellScheduleApply(t *testing.T) {
+	bells := BellSchedule{1: {Start: "08:00", End: "08:50"}}
+
+	tests := []struct {
+		name               string
+		schedule           Schedule
+		wantStart, wantEnd string
+	}{
+		{"known period", Schedule{TimePeriod: 1}, "08:00", "08:50"},
+		{"unknown period", Schedule{TimePeriod: 9, StartTime: "stale", EndTime: "stale"}, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := tt.schedule
+			bells.Apply(&schedule)
+
+			if schedule.StartTime != tt.wantStart || schedule.EndTime != tt.wantEnd {
+				t.Errorf("got %q-%q, want %q-%q", schedule.StartTime, schedule.EndTime, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package model
+
+import (
+	"time"
+
+	"github.com/21b030939/golang-project/pkg/cache"
+)
+
+// Cache holds the lookups made while authenticating and authorizing every request: the user of
+// a token and the permissions of a user. The models that change users, tokens, permissions and
+// roles invalidate the affected entries. The cache is local to the process, so with several
+// instances a change made through one of them is only seen by the others once the entries
+// expire. A nil *Cache disables caching.
+type Cache struct {
+	tokens      *cache.Cache[string, User]
+	permissions *cache.Cache[int64, Permissions]
+}
+
+// NewCache returns a cache holding at most size entries of each kind for at most ttl.
+func NewCache(size int, ttl time.Duration) *Cache {
+	return &Cache{
+		tokens:      cache.New[string, User](size, ttl),
+		permissions: cache.New[int64, Permissions](size, ttl),
+	}
+}
+
+// Use makes the models use the cache.
+func (m *Models) Use(c *Cache) {
+	m.Users.Cache = c
+	m.Tokens.Cache = c
+	m.Permissions.Cache = c
+	m.Roles.Cache = c
+}
+
+// Stats returns the counters of the token and permission caches.
+func (c *Cache) Stats() map[string]cache.Stats {
+	if c == nil {
+		return nil
+	}
+
+	return map[string]cache.Stats{
+		"tokens":      c.tokens.Stats(),
+		"permissions": c.permissions.Stats(),
+	}
+}
+
+func (c *Cache) tokenCache() *cache.Cache[string, User] {
+	if c == nil {
+		return nil
+	}
+	return c.tokens
+}
+
+func (c *Cache) permissionCache() *cache.Cache[int64, Permissions] {
+	if c == nil {
+		return nil
+	}
+	return c.permissions
+}
+
+// tokenKey returns the cache key of the token with the given scope and hash.
+func tokenKey(scope string, hash []byte) string {
+	return scope + ":" + string(hash)
+}
+
+// invalidateToken removes the cached user of a single token.
+func (c *Cache) invalidateToken(scope string, hash []byte) {
+	c.tokenCache().Delete(tokenKey(scope, hash))
+}
+
+// invalidateUser removes the cached user of every token of the user.
+func (c *Cache) invalidateUser(userID int64) {
+	c.tokenCache().DeleteFunc(func(_ string, user User) bool {
+		return user.ID == userID
+	})
+}
+
+// invalidatePermissions removes the cached permissions of the user.
+func (c *Cache) invalidatePermissions(userID int64) {
+	c.permissionCache().Delete(userID)
+}
@@ -1,10 +1,17 @@
 package model
 
 import (
-	// "database/sql"
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"log"
+	"strconv"
+	"time"
+
 	"github.com/jmoiron/sqlx"
+
+	"github.com/21b030939/golang-project/pkg/schedule/validator"
 )
 
 type Discipline struct {
@@ -14,56 +21,186 @@ type Discipline struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Credits     string `json:"credits"`
+	Version     int    `json:"version"`
 }
 
 type DisciplineModel struct {
 	DB       *sqlx.DB
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
+	Timeout  time.Duration
+}
+
+// GetAll returns a page of disciplines matching the provided name and credits filters. An empty
+// name or credits value disables the corresponding filter.
+func (m DisciplineModel) GetAll(ctx context.Context, name, credits string, filters Filters) ([]*Discipline, Metadata, error) {
+	// The name filter uses a case-insensitive substring match, so "calc" finds both
+	// "Calculus" and "Calculus II".
+	query := fmt.Sprintf(
+		`
+		SELECT count(*) OVER(), id, created_at, updated_at, name,
+			COALESCE(description, ''), COALESCE(credits, ''), version
+		FROM discipline
+		WHERE (name ILIKE '%%' || $1 || '%%' OR $1 = '')
+		AND (credits = $2 OR $2 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4
+		`,
+		filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	args := []interface{}{name, credits, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	totalRecords := 0
+
+	var disciplines []*Discipline
+	for rows.Next() {
+		var discipline Discipline
+		err := rows.Scan(&totalRecords, &discipline.Id, &discipline.CreatedAt, &discipline.UpdatedAt,
+			&discipline.Name, &discipline.Description, &discipline.Credits, &discipline.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		disciplines = append(disciplines, &discipline)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return disciplines, metadata, nil
 }
 
-var disciplines = []Discipline{
-	{
-		Id:          "1",
-		Name:        "Discrete Structures",
-		Description: "This course covers introductory topics in discrete mathematics such as sets, mathematical reasoning and proofs,combinatorial counting methods and generating functions, basics of number theory, and basics of graph theory.",
-		Credits:     "3",
-	},
-	{
-		Id:          "2",
-		Name:        "Calculus II",
-		Description: "This course is the second part of a mathematics course. It contains the following chapters: antiderivatives; definite integrals; applications of definite integrals; differentiable calculus of functions of two or more variables; multiple integrals.",
-		Credits:     "3",
-	},
-	{
-		Id:          "3",
-		Name:        "Programming Principles I",
-		Description: "C++ was designed with systems programming and embedded, resource-constrained software and large systems in mind, with performance, efficiency, and flexibility of use as its design highlights.",
-		Credits:     "4",
-	},
-	{
-		Id:          "4",
-		Name:        "Android Development",
-		Description: "Tools and APIs required building applications for the Android platform using the Android SDK. User interface designs for mobile devices and unique user interactions using multi-touch technologies. Object-oriented design using model-view-controller paradigm, memory management, Java (Kotlin) programming language. Other topics include: object-oriented database API, animation, multi-threading and performance considerations.",
-		Credits:     "3",
-	},
-	{
-		Id:          "5",
-		Name:        "Golang Application Development",
-		Description: "Go is a statically typed, compiled high-level programming language designed at Google by Robert Griesemer, Rob Pike, and Ken Thompson. It is syntactically similar to C, but also has memory safety, garbage collection, structural typing, and CSP-style concurrency.",
-		Credits:     "4",
-	},
+// Insert adds a new discipline record and fills in the generated id, timestamps and version.
+func (m DisciplineModel) Insert(ctx context.Context, discipline *Discipline) error {
+	query := `
+		INSERT INTO discipline (name, description, credits)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, updated_at, version
+		`
+	args := []interface{}{discipline.Name, discipline.Description, discipline.Credits}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&discipline.Id, &discipline.CreatedAt,
+		&discipline.UpdatedAt, &discipline.Version)
 }
 
-func GetDisciplines() []Discipline {
-	return disciplines
+// Get returns a specific discipline by its id, or ErrRecordNotFound if there is no such record.
+func (m DisciplineModel) Get(ctx context.Context, id int) (*Discipline, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, updated_at, name, COALESCE(description, ''), COALESCE(credits, ''), version
+		FROM discipline
+		WHERE id = $1
+		`
+	var discipline Discipline
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&discipline.Id, &discipline.CreatedAt,
+		&discipline.UpdatedAt, &discipline.Name, &discipline.Description, &discipline.Credits,
+		&discipline.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &discipline, nil
 }
 
-func GetDiscipline(id string) (*Discipline, error) {
-	for _, r := range disciplines {
-		if r.Id == id {
-			return &r, nil
+// Update saves the discipline, checking against the version field so that two clients editing
+// the same record concurrently can't silently overwrite each other. If the version has moved on
+// since the record was read, ErrEditConflict is returned.
+func (m DisciplineModel) Update(ctx context.Context, discipline *Discipline) error {
+	query := `
+		UPDATE discipline
+		SET name = $1, description = $2, credits = $3, updated_at = CURRENT_TIMESTAMP, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING updated_at, version
+		`
+	args := []interface{}{discipline.Name, discipline.Description, discipline.Credits, discipline.Id,
+		discipline.Version}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&discipline.UpdatedAt, &discipline.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
 		}
 	}
-	return nil, errors.New("discipline not found")
+
+	return nil
+}
+
+// Delete removes a specific discipline. It returns ErrRecordNotFound if no rows were affected.
+func (m DisciplineModel) Delete(ctx context.Context, id int) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM discipline
+		WHERE id = $1
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func ValidateDiscipline(v *validator.Validator, discipline *Discipline) {
+	// Check if the name field is empty and not more than 200 characters.
+	v.Check(discipline.Name != "", "name", "must be provided")
+	v.Check(len(discipline.Name) <= 200, "name", "must not be more than 200 bytes long")
+	// Check if the description field is not more than 5000 characters.
+	v.Check(len(discipline.Description) <= 5000, "description", "must not be more than 5000 bytes long")
+	// Check that credits is a whole, positive number of credits.
+	credits, err := strconv.Atoi(discipline.Credits)
+	v.Check(err == nil, "credits", "must be an integer value")
+	v.Check(err != nil || (credits > 0 && credits <= 30), "credits", "must be between 1 and 30")
 }
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/21b030939/golang-project/pkg/schedule/validator"
+)
+
+func TestValidateDiscipline(t *testing.T) {
+	tests := []struct {
+		name       string
+		discipline Discipline
+		wantErrors map[string]string
+	}{
+		{
+			name:       "valid",
+			discipline: Discipline{Name: "Calculus II", Description: "Integrals.", Credits: "3"},
+		},
+		{
+			name:       "no description",
+			discipline: Discipline{Name: "Calculus II", Credits: "30"},
+		},
+		{
+			name:       "no name",
+			discipline: Discipline{Credits: "3"},
+			wantErrors: map[string]string{"name": "must be provided"},
+		},
+		{
+			name:       "long name",
+			discipline: Discipline{Name: strings.Repeat("a", 201), Credits: "3"},
+			wantErrors: map[string]string{"name": "must not be more than 200 bytes long"},
+		},
+		{
+			name:       "long description",
+			discipline: Discipline{Name: "Calculus II", Description: strings.Repeat("a", 5001), Credits: "3"},
+			wantErrors: map[string]string{"description": "must not be more than 5000 bytes long"},
+		},
+		{
+			name:       "credits not a number",
+			discipline: Discipline{Name: "Calculus II", Credits: "three"},
+			wantErrors: map[string]string{"credits": "must be an integer value"},
+		},
+		{
+			name:       "no credits",
+			discipline: Discipline{Name: "Calculus II", Credits: "0"},
+			wantErrors: map[string]string{"credits": "must be between 1 and 30"},
+		},
+		{
+			name:       "too many credits",
+			discipline: Discipline{Name: "Calculus II", Credits: "31"},
+			wantErrors: map[string]string{"credits": "must be between 1 and 30"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateDiscipline(v, &tt.discipline)
+
+			if len(v.Errors) != len(tt.wantErrors) {
+				t.Fatalf("errors = %v, want %v", v.Errors, tt.wantErrors)
+			}
+			for key, want := range tt.wantErrors {
+				if got := v.Errors[key]; got != want {
+					t.Errorf("errors[%q] = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
@@ -1,34 +1,92 @@
 package filler
 
 import (
+	"context"
+	"strconv"
+
 	model "github.com/21b030939/golang-project/pkg/schedule/model"
 )
 
-func PopulateDatabase(models model.Models) error {
+func PopulateDatabase(ctx context.Context, models model.Models) error {
+	// Remember the id of every discipline we insert, so that the schedules below can be linked
+	// to their discipline by name.
+	disciplineIDs := make(map[string]int64)
+
+	for _, discipline := range disciplines {
+		err := models.Disciplines.Insert(ctx, &discipline)
+		if err != nil {
+			return err
+		}
+		disciplineIDs[discipline.Name], _ = strconv.ParseInt(discipline.Id, 10, 64)
+	}
+
 	for _, schedule := range schedules {
-		models.Schedules.Insert(&schedule)
+		// Some seeded schedules reference disciplines that aren't in the list above, create
+		// a bare discipline for them so that every schedule gets linked.
+		id, ok := disciplineIDs[schedule.Discipline]
+		if !ok {
+			discipline := model.Discipline{Name: schedule.Discipline, Credits: "3"}
+			err := models.Disciplines.Insert(ctx, &discipline)
+			if err != nil {
+				return err
+			}
+			id, _ = strconv.ParseInt(discipline.Id, 10, 64)
+			disciplineIDs[discipline.Name] = id
+		}
+
+		schedule.DisciplineId = id
+		err := models.Schedules.Insert(ctx, &schedule)
+		if err != nil {
+			return err
+		}
 	}
-	// TODO: Implement disciplines pupulation
-	// TODO: Implement the relationship between disciplines and schedules
 	return nil
 }
 
+var disciplines = []model.Discipline{
+	{
+		Name:        "Discrete Structures",
+		Description: "This course covers introductory topics in discrete mathematics such as sets, mathematical reasoning and proofs,combinatorial counting methods and generating functions, basics of number theory, and basics of graph theory.",
+		Credits:     "3",
+	},
+	{
+		Name:        "Calculus II",
+		Description: "This course is the second part of a mathematics course. It contains the following chapters: antiderivatives; definite integrals; applications of definite integrals; differentiable calculus of functions of two or more variables; multiple integrals.",
+		Credits:     "3",
+	},
+	{
+		Name:        "Programming Principles I",
+		Description: "C++ was designed with systems programming and embedded, resource-constrained software and large systems in mind, with performance, efficiency, and flexibility of use as its design highlights.",
+		Credits:     "4",
+	},
+	{
+		Name:        "Android Development",
+		Description: "Tools and APIs required building applications for the Android platform using the Android SDK. User interface designs for mobile devices and unique user interactions using multi-touch technologies. Object-oriented design using model-view-controller paradigm, memory management, Java (Kotlin) programming language. Other topics include: object-oriented database API, animation, multi-threading and performance considerations.",
+		Credits:     "3",
+	},
+	{
+		Name:        "Golang Application Development",
+		Description: "Go is a statically typed, compiled high-level programming language designed at Google by Robert Griesemer, Rob Pike, and Ken Thompson. It is syntactically similar to C, but also has memory safety, garbage collection, structural typing, and CSP-style concurrency.",
+		Credits:     "4",
+	},
+}
+
 var schedules = []model.Schedule{
-	{Discipline: "Calculus", Cabinet: "256", TimePeriod: 3},
-	{Discipline: "Discrete Structures", Cabinet: "269a", TimePeriod: 3},
-	{Discipline: "Linear Algebra", Cabinet: "259", TimePeriod: 3},
-	{Discipline: "Calculus II", Cabinet: "283", TimePeriod: 3},
-	{Discipline: "Statistics", Cabinet: "351", TimePeriod: 4},
-	{Discipline: "Programming Principles", Cabinet: "269", TimePeriod: 4},
-	{Discipline: "OOP", Cabinet: "269", TimePeriod: 4},
-	{Discipline: "Programming Principles II", Cabinet: "461", TimePeriod: 4},
-	{Discipline: "Databases", Cabinet: "428", TimePeriod: 3},
-	{Discipline: "Algorithms", Cabinet: "Konaev Hall", TimePeriod: 3},
-	{Discipline: "Android Development", Cabinet: "272", TimePeriod: 3},
-	{Discipline: "Advanced Android", Cabinet: "272", TimePeriod: 3},
-	{Discipline: "Golang", Cabinet: "383", TimePeriod: 3},
-	{Discipline: "Spring", Cabinet: "444", TimePeriod: 3},
-	{Discipline: "Web Development", Cabinet: "359", TimePeriod: 4},
-	{Discipline: "IOS Development", Cabinet: "283", TimePeriod: 3},
-	{Discipline: "Software Development", Cabinet: "461", TimePeriod: 3},
+	{Discipline: "Calculus", Cabinet: "256", TimePeriod: 3, DayOfWeek: 1},
+	{Discipline: "Discrete Structures", Cabinet: "269a", TimePeriod: 3, DayOfWeek: 1},
+	{Discipline: "Linear Algebra", Cabinet: "259", TimePeriod: 3, DayOfWeek: 2},
+	{Discipline: "Calculus II", Cabinet: "283", TimePeriod: 3, DayOfWeek: 2},
+	{Discipline: "Statistics", Cabinet: "351", TimePeriod: 4, DayOfWeek: 3},
+	{Discipline: "Programming Principles", Cabinet: "269", TimePeriod: 4, DayOfWeek: 3},
+	{Discipline: "OOP", Cabinet: "269", TimePeriod: 5, DayOfWeek: 3},
+	{Discipline: "Programming Principles II", Cabinet: "461", TimePeriod: 4, DayOfWeek: 4},
+	{Discipline: "Databases", Cabinet: "428", TimePeriod: 3, DayOfWeek: 4},
+	{Discipline: "Algorithms", Cabinet: "Konaev Hall", TimePeriod: 3, DayOfWeek: 5},
+	{Discipline: "Android Development", Cabinet: "272", TimePeriod: 3, DayOfWeek: 1},
+	{Discipline: "Advanced Android", Cabinet: "272", TimePeriod: 4, DayOfWeek: 1},
+	{Discipline: "Golang", Cabinet: "383", TimePeriod: 3, DayOfWeek: 2},
+	{Discipline: "Spring", Cabinet: "444", TimePeriod: 3, DayOfWeek: 5},
+	{Discipline: "Web Development", Cabinet: "359", TimePeriod: 4, DayOfWeek: 4},
+	{Discipline: "IOS Development", Cabinet: "283", TimePeriod: 4, DayOfWeek: 2},
+	{Discipline: "Software Development", Cabinet: "461", TimePeriod: 3, DayOfWeek: 5},
 }
@@ -1,10 +1,11 @@
 package model
 
 import (
-	// "database/sql"
+	"database/sql"
 	"errors"
 	"log"
 	"os"
+	"time"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -14,6 +15,9 @@ var (
 
 	// ErrEditConflict is returned when a there is a data race, and we have an edit conflict.
 	ErrEditConflict = errors.New("edit conflict")
+
+	// ErrScheduleConflict is returned when a schedule would double-book a cabinet.
+	ErrScheduleConflict = errors.New("schedule conflict")
 )
 
 type Models struct {
@@ -22,9 +26,16 @@ type Models struct {
 	Users       	UserModel
 	Tokens      	TokenModel
 	Permissions 	PermissionModel
+	Roles       	RoleModel
+	Schema      	SchemaModel
+	TOTP        	TOTPModel
+	LoginThrottles	LoginThrottleModel
+	APIKeys     	APIKeyModel
 }
 
-func NewModels(db *sqlx.DB) Models {
+// NewModels returns the models backed by db. Every query is cancelled after queryTimeout, or
+// earlier if the context passed to the model method is cancelled.
+func NewModels(db *sqlx.DB, queryTimeout time.Duration) Models {
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 	return Models{
@@ -32,26 +43,80 @@ func NewModels(db *sqlx.DB) Models {
 			DB:       db,
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
+			Timeout:  queryTimeout,
 		},
 		Disciplines: DisciplineModel{
 			DB:       db,
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
+			Timeout:  queryTimeout,
 		},
 		Users: UserModel{
 			DB:       db,
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
+			Timeout:  queryTimeout,
 		},
 		Tokens: TokenModel{
 			DB:       db,
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
+			Timeout:  queryTimeout,
 		},
 		Permissions: PermissionModel{
 			DB:       db,
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
+			Timeout:  queryTimeout,
+		},
+		Roles: RoleModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+			Timeout:  queryTimeout,
+		},
+		Schema: SchemaModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+			Timeout:  queryTimeout,
+		},
+		TOTP: TOTPModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+			Timeout:  queryTimeout,
+		},
+		LoginThrottles: LoginThrottleModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+			Timeout:  queryTimeout,
+		},
+		APIKeys: APIKeyModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+			Timeout:  queryTimeout,
 		},
 	}
-}
\ No newline at end of file
+}
+
+// expectRows turns the result of a DELETE or UPDATE into ErrRecordNotFound if no rows were
+// affected.
+func expectRows(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -24,23 +24,58 @@ func (p Permissions) Include(code string) bool {
 	return false
 }
 
+// Intersect returns the codes that are in both p and q, in the order of p.
+func (p Permissions) Intersect(q Permissions) Permissions {
+	intersection := Permissions{}
+	for _, code := range p {
+		if q.Include(code) {
+			intersection = append(intersection, code)
+		}
+	}
+
+	return intersection
+}
+
 type PermissionModel struct {
 	DB       *sqlx.DB
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
+	Timeout  time.Duration
+	Cache    *Cache
 }
 
-// GetAllForUser returns all permission codes for a specific user in a Permissions slice.
-func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+// GetAllForUser returns all permission codes for a specific user in a Permissions slice. These
+// are the codes granted to the user directly along with the codes of every role the user is
+// assigned to. Lookups are cached until the cache TTL passes or the user's permissions change.
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	permissions, err := m.Cache.permissionCache().GetOrLoad(userID, func() (Permissions, time.Time, error) {
+		permissions, err := m.getAllForUser(ctx, userID)
+		return permissions, time.Time{}, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy the cached slice, so that callers can't modify it.
+	return append(Permissions(nil), permissions...), nil
+}
+
+func (m PermissionModel) getAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
 		SELECT permissions.code
 		FROM permissions
 			INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-			INNER JOIN users ON users_permissions.user_id = users.id
-		WHERE users.id = $1
+		WHERE users_permissions.user_id = $1
+		UNION
+		SELECT permissions.code
+		FROM permissions
+			INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+			INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+		WHERE users_roles.user_id = $1
+		ORDER BY code
 		`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID)
@@ -73,16 +108,59 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	return permissions, nil
 }
 
-// AddForUser adds the provided codes for a specific user.
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+// GetAll returns every permission code that can be granted, in alphabetical order.
+func (m PermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY code
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var permissions Permissions
+
+	err := m.DB.SelectContext(ctx, &permissions, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// AddForUser adds the provided codes for a specific user. Codes the user already has are
+// skipped, so granting a permission twice is not an error.
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	query := `
 		INSERT INTO users_permissions
 		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	m.Cache.invalidatePermissions(userID)
+	return err
+}
+
+// RemoveForUser revokes the provided codes from a specific user. Codes the user doesn't have are
+// ignored.
+func (m PermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+		DELETE FROM users_permissions
+		USING permissions
+		WHERE users_permissions.permission_id = permissions.id
+			AND users_permissions.user_id = $1
+			AND permissions.code = ANY($2)
 		`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	m.Cache.invalidatePermissions(userID)
 	return err
 }
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPermissionsIntersect(t *testing.T) {
+	tests := []struct {
+		name string
+		p, q Permissions
+		want Permissions
+	}{
+		{"both empty", nil, nil, Permissions{}},
+		{"empty key", nil, Permissions{"schedules:read"}, Permissions{}},
+		{"owner lost every code", Permissions{"schedules:read"}, nil, Permissions{}},
+		{"subset", Permissions{"schedules:read"}, Permissions{"schedules:read", "schedules:write"}, Permissions{"schedules:read"}},
+		{
+			"code revoked from the owner",
+			Permissions{"schedules:read", "schedules:write"},
+			Permissions{"schedules:read"},
+			Permissions{"schedules:read"},
+		},
+		{
+			"keeps the order of p",
+			Permissions{"schedules:write", "disciplines:write", "schedules:read"},
+			Permissions{"schedules:read", "schedules:write"},
+			Permissions{"schedules:write", "schedules:read"},
+		},
+		{"disjoint", Permissions{"disciplines:write"}, Permissions{"schedules:write"}, Permissions{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.p.Intersect(tt.q)
+			if got == nil || !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
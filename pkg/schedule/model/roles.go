@@ -0,0 +1,136 @@
+package model
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Role names seeded by the migrations.
+const (
+	RoleStudent   = "student"
+	RoleTeacher   = "teacher"
+	RoleDean      = "dean"
+	RoleRegistrar = "registrar"
+	RoleAdmin     = "admin"
+)
+
+// Role is a named bundle of permission codes. Users assigned to a role are granted all of its
+// codes, in addition to the codes granted to them directly.
+type Role struct {
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+}
+
+type RoleModel struct {
+	DB       *sqlx.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Timeout  time.Duration
+	Cache    *Cache
+}
+
+// GetAll returns every role along with its permission codes, in alphabetical order.
+func (m RoleModel) GetAll(ctx context.Context) ([]*Role, error) {
+	query := `
+		SELECT roles.name, COALESCE(array_agg(permissions.code ORDER BY permissions.code)
+			FILTER (WHERE permissions.code IS NOT NULL), '{}')
+		FROM roles
+			LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+			LEFT JOIN permissions ON roles_permissions.permission_id = permissions.id
+		GROUP BY roles.name
+		ORDER BY roles.name
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+
+		err := rows.Scan(&role.Name, pq.Array(&role.Permissions))
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// GetAllForUser returns the names of the roles a user is assigned to, in alphabetical order.
+func (m RoleModel) GetAllForUser(ctx context.Context, userID int64) ([]string, error) {
+	query := `
+		SELECT roles.name
+		FROM roles
+			INNER JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = $1
+		ORDER BY roles.name
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	roles := []string{}
+
+	err := m.DB.SelectContext(ctx, &roles, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// AddForUser assigns a user to the named roles. Roles the user already has are skipped.
+func (m RoleModel) AddForUser(ctx context.Context, userID int64, names ...string) error {
+	query := `
+		INSERT INTO users_roles
+		SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+		ON CONFLICT DO NOTHING
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	m.Cache.invalidatePermissions(userID)
+	return err
+}
+
+// RemoveForUser removes a user from the named roles. Roles the user doesn't have are ignored.
+func (m RoleModel) RemoveForUser(ctx context.Context, userID int64, names ...string) error {
+	query := `
+		DELETE FROM users_roles
+		USING roles
+		WHERE users_roles.role_id = roles.id
+			AND users_roles.user_id = $1
+			AND roles.name = ANY($2)
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	m.Cache.invalidatePermissions(userID)
+	return err
+}
@@ -1,55 +1,124 @@
 package model
 
 import (
-	// "database/sql"
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"time"
+
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 
 	"github.com/21b030939/golang-project/pkg/schedule/validator"
 )
 
 type Schedule struct {
-	Id         string `json:"id"`
-	CreatedAt  string `json:"createdAt"`
-	UpdatedAt  string `json:"updatedAt"`
+	Id           string `json:"id"`
+	CreatedAt    string `json:"createdAt"`
+	UpdatedAt    string `json:"updatedAt"`
+	DisciplineId int64  `json:"disciplineId"`
+	// Discipline holds the discipline name. It is copied from the discipline table whenever the
+	// link is written, so rows created before disciplines were linked keep their free-text name.
 	Discipline string `json:"discipline"`
 	Cabinet    string `json:"cabinet"`
 	TimePeriod int    `json:"timePeriod"`
+	// DayOfWeek follows ISO 8601, 1 is Monday and 7 is Sunday.
+	DayOfWeek int `json:"dayOfWeek"`
+	// WeekParity is ParityOdd or ParityEven for classes that take place every other week, and
+	// empty for classes that take place every week.
+	WeekParity string `json:"weekParity"`
+	// StartTime and EndTime are resolved from TimePeriod through the bell schedule.
+	StartTime string `json:"startTime,omitempty"`
+	EndTime   string `json:"endTime,omitempty"`
+	// DisciplineDetails is only filled in when a single schedule is fetched with Get.
+	DisciplineDetails *Discipline `json:"disciplineDetails,omitempty"`
+}
+
+// ScheduleConflict describes a cabinet that is booked by more than one schedule for the same day
+// and time period.
+type ScheduleConflict struct {
+	Cabinet     string  `json:"cabinet"`
+	DayOfWeek   int     `json:"dayOfWeek"`
+	TimePeriod  int     `json:"timePeriod"`
+	ScheduleIds []int64 `json:"scheduleIds"`
+}
+
+// ScheduleConflictError is returned by Insert and Update when the schedule would double-book a
+// cabinet. It matches ErrScheduleConflict with errors.Is, and carries the ids of the schedules
+// that already occupy the cabinet.
+type ScheduleConflictError struct {
+	ScheduleIds []int64
+}
+
+func (e *ScheduleConflictError) Error() string {
+	return fmt.Sprintf("%s with schedules %v", ErrScheduleConflict, e.ScheduleIds)
+}
+
+func (e *ScheduleConflictError) Is(target error) bool {
+	return target == ErrScheduleConflict
+}
+
+// ScheduleImportError is returned by InsertMany when some of the schedules double-book a
+// cabinet. Conflicts is keyed by the index of the schedule in the slice passed to InsertMany.
+type ScheduleImportError struct {
+	Conflicts map[int]*ScheduleConflictError
+}
+
+func (e *ScheduleImportError) Error() string {
+	return fmt.Sprintf("%s in %d schedules", ErrScheduleConflict, len(e.Conflicts))
+}
+
+func (e *ScheduleImportError) Is(target error) bool {
+	return target == ErrScheduleConflict
 }
 
 type ScheduleModel struct {
 	DB       *sqlx.DB
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
+	Timeout  time.Duration
+	// ImportTimeout bounds InsertMany, which runs a query per schedule in a single transaction.
+	// It defaults to Timeout if it's zero.
+	ImportTimeout time.Duration
+	// Bells resolves the start and end times of the schedules returned by the model.
+	Bells BellSchedule
 }
 
-func (m ScheduleModel) GetAll(discipline string, from, to int, filters Filters) ([]*Schedule, Metadata, error) {
-
-	// Retrieve all menu items from the database.
+// GetAll returns a page of schedules. The discipline argument filters on the discipline name,
+// disciplineID on the linked discipline and day on the day of week; zero values disable the
+// corresponding filter.
+func (m ScheduleModel) GetAll(ctx context.Context, discipline string, disciplineID, day int, from, to int, filters Filters) ([]*Schedule, Metadata, error) {
 
+	// Retrieve all schedule items from the database. The discipline name is taken from the linked
+	// discipline when there is one and falls back to the legacy free-text column otherwise.
 	query := fmt.Sprintf(
 		`
-		SELECT count(*) OVER(), id, created_at, updated_at, discipline, cabinet, time_period
-		FROM schedule
-		WHERE (discipline = $1 OR $1 = '')
-		AND (time_period >= $2 OR $2 = 0)
-		AND (time_period <= $3 OR $3 = 0)
+		SELECT count(*) OVER(), s.id, s.created_at, s.updated_at, COALESCE(ds.discipline, 0),
+			COALESCE(d.name, s.discipline) AS discipline, s.cabinet, s.time_period, s.day_of_week,
+			s.week_parity
+		FROM schedule s
+		LEFT JOIN discipline_schedule ds ON ds.schedule = s.id
+		LEFT JOIN discipline d ON d.id = ds.discipline
+		WHERE (COALESCE(d.name, s.discipline) = $1 OR $1 = '')
+		AND (ds.discipline = $2 OR $2 = 0)
+		AND (s.day_of_week = $3 OR $3 = 0)
+		AND (s.time_period >= $4 OR $4 = 0)
+		AND (s.time_period <= $5 OR $5 = 0)
 		ORDER BY %s %s, id ASC
-		LIMIT $4 OFFSET $5
+		LIMIT $6 OFFSET $7
 		`,
 		filters.sortColumn(), filters.sortDirection())
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Create a context with the configured query timeout.
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	// Organize our four placeholder parameter values in a slice.
-	args := []interface{}{discipline, from, to, filters.limit(), filters.offset()}
+	// Organize our placeholder parameter values in a slice.
+	args := []interface{}{discipline, disciplineID, day, from, to, filters.limit(), filters.offset()}
 
-	// log.Println(query, discipline, from, to, filters.limit(), filters.offset())
 	// Use QueryContext to execute the query. This returns a sql.Rows result set containing
 	// the result.
 	rows, err := m.DB.QueryContext(ctx, query, args...)
@@ -71,12 +140,12 @@ func (m ScheduleModel) GetAll(discipline string, from, to int, filters Filters)
 	var schedules []*Schedule
 	for rows.Next() {
 		var schedule Schedule
-		err := rows.Scan(&totalRecords, &schedule.Id, &schedule.CreatedAt, &schedule.UpdatedAt, &schedule.Discipline, &schedule.Cabinet, &schedule.TimePeriod)
+		err := rows.Scan(&totalRecords, &schedule.Id, &schedule.CreatedAt, &schedule.UpdatedAt, &schedule.DisciplineId, &schedule.Discipline, &schedule.Cabinet, &schedule.TimePeriod, &schedule.DayOfWeek, &schedule.WeekParity)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
-		// Add the Movie struct to the slice
+		// Add the Schedule struct to the slice
 		schedules = append(schedules, &schedule)
 	}
 
@@ -90,87 +159,398 @@ func (m ScheduleModel) GetAll(discipline string, from, to int, filters Filters)
 	// from the client.
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 
-	// If everything went OK, then return the slice of the movies and metadata.
+	m.Bells.Apply(schedules...)
+
+	// If everything went OK, then return the slice of the schedules and metadata.
 	return schedules, metadata, nil
 }
 
-func (m ScheduleModel) Insert(schedule *Schedule) error {
-	// Insert a new schedule item into the database.
-	query := `
-		INSERT INTO schedule (discipline, cabinet, time_period) 
-		VALUES ($1, $2, $3) 
-		RETURNING id, created_at, updated_at
-		`
-	args := []interface{}{schedule.Discipline, schedule.Cabinet, schedule.TimePeriod}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// Insert adds a new schedule item and links it to its discipline. Both rows are written in a
+// single transaction, so a schedule never exists without its discipline_schedule link.
+func (m ScheduleModel) Insert(ctx context.Context, schedule *Schedule) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&schedule.Id, &schedule.CreatedAt, &schedule.UpdatedAt)
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkConflicts(ctx, tx, schedule)
+	if err != nil {
+		return err
+	}
+
+	err = insertSchedule(ctx, tx, schedule)
+	if err != nil {
+		return err
+	}
+
+	m.Bells.Apply(schedule)
+
+	return tx.Commit()
 }
 
-func (m ScheduleModel) Get(id int) (*Schedule, error) {
+// InsertMany adds all the schedules in a single transaction: either every schedule is inserted,
+// or none is. Schedules are checked for conflicts against the existing data and against each
+// other. If any of them conflicts, nothing is inserted and a *ScheduleImportError reporting the
+// conflict of every affected schedule is returned.
+func (m ScheduleModel) InsertMany(ctx context.Context, schedules []*Schedule) error {
+	// Imports may hold hundreds of rows, so allow more time than a single insert.
+	timeout := m.ImportTimeout
+	if timeout == 0 {
+		timeout = m.Timeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	importErr := &ScheduleImportError{Conflicts: make(map[int]*ScheduleConflictError)}
+
+	for i, schedule := range schedules {
+		err = checkConflicts(ctx, tx, schedule)
+		if err != nil {
+			var conflictErr *ScheduleConflictError
+			switch {
+			case errors.As(err, &conflictErr):
+				// Keep going, so the report covers every conflicting schedule and not just
+				// the first one. The conflicting schedule itself is not inserted.
+				importErr.Conflicts[i] = conflictErr
+				continue
+			default:
+				return err
+			}
+		}
+
+		err = insertSchedule(ctx, tx, schedule)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(importErr.Conflicts) > 0 {
+		return importErr
+	}
+
+	m.Bells.Apply(schedules...)
+
+	return tx.Commit()
+}
+
+func (m ScheduleModel) Get(ctx context.Context, id int) (*Schedule, error) {
 	// Return an error if the ID is less than 1.
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	// Retrieve a specific schedule item based on its ID.
+	// Retrieve a specific schedule item based on its ID, together with its discipline.
 	query := `
-		SELECT id, created_at, updated_at, discipline, cabinet, time_period
-		FROM schedule
-		WHERE id = $1
+		SELECT s.id, s.created_at, s.updated_at, COALESCE(ds.discipline, 0),
+			COALESCE(d.name, s.discipline), s.cabinet, s.time_period, s.day_of_week, s.week_parity,
+			d.id, d.created_at, d.updated_at, d.name, d.description, d.credits, d.version
+		FROM schedule s
+		LEFT JOIN discipline_schedule ds ON ds.schedule = s.id
+		LEFT JOIN discipline d ON d.id = ds.discipline
+		WHERE s.id = $1
 		`
-	var schedule Schedule
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	var (
+		schedule   Schedule
+		discipline nullDiscipline
+	)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&schedule.Id, &schedule.CreatedAt, &schedule.UpdatedAt, &schedule.Discipline, &schedule.Cabinet, &schedule.TimePeriod)
+	err := row.Scan(&schedule.Id, &schedule.CreatedAt, &schedule.UpdatedAt, &schedule.DisciplineId,
+		&schedule.Discipline, &schedule.Cabinet, &schedule.TimePeriod, &schedule.DayOfWeek,
+		&schedule.WeekParity, &discipline.Id, &discipline.CreatedAt, &discipline.UpdatedAt, &discipline.Name,
+		&discipline.Description, &discipline.Credits, &discipline.Version)
 	if err != nil {
-		return nil, fmt.Errorf("cannot retrive menu with id: %v, %w", id, err)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, fmt.Errorf("cannot retrive schedule with id: %v, %w", id, err)
+		}
 	}
+
+	schedule.DisciplineDetails = discipline.discipline()
+
+	m.Bells.Apply(&schedule)
+
 	return &schedule, nil
 }
 
-func (m ScheduleModel) Update(schedule *Schedule) error {
+// nullDiscipline holds the discipline columns of a schedule LEFT JOINed with its discipline,
+// which are all NULL when the schedule isn't linked to one.
+type nullDiscipline struct {
+	Id, CreatedAt, UpdatedAt, Name, Description, Credits sql.NullString
+	Version                                              sql.NullInt64
+}
+
+// discipline returns the joined discipline, or nil if the schedule isn't linked to one.
+func (d nullDiscipline) discipline() *Discipline {
+	if !d.Id.Valid {
+		return nil
+	}
+
+	return &Discipline{
+		Id:          d.Id.String,
+		CreatedAt:   d.CreatedAt.String,
+		UpdatedAt:   d.UpdatedAt.String,
+		Name:        d.Name.String,
+		Description: d.Description.String,
+		Credits:     d.Credits.String,
+		Version:     int(d.Version.Int64),
+	}
+}
+
+// Update saves the schedule and its discipline link in one transaction. The updated_at column is
+// used as an optimistic lock: if the row changed since it was read, ErrEditConflict is returned.
+func (m ScheduleModel) Update(ctx context.Context, schedule *Schedule) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	// Update a specific schedule item in the database.
 	query := `
 		UPDATE schedule
-		SET discipline = $1, cabinet = $2, time_period = $3, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $4 AND updated_at = $5
+		SET discipline = $1, cabinet = $2, time_period = $3, day_of_week = $4, week_parity = $5,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $6 AND updated_at = $7
 		RETURNING updated_at
 	`
-	args := []interface{}{schedule.Discipline, schedule.Cabinet, schedule.TimePeriod, schedule.Id, schedule.UpdatedAt}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args := []interface{}{schedule.Discipline, schedule.Cabinet, schedule.TimePeriod, schedule.DayOfWeek,
+		schedule.WeekParity, schedule.Id, schedule.UpdatedAt}
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&schedule.UpdatedAt)
+	err = checkConflicts(ctx, tx, schedule)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&schedule.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = linkDiscipline(ctx, tx, schedule)
+	if err != nil {
+		return err
+	}
+
+	m.Bells.Apply(schedule)
+
+	return tx.Commit()
 }
 
-func (m ScheduleModel) Delete(id int) error {
+func (m ScheduleModel) Delete(ctx context.Context, id int) error {
 	// Return an error if the ID is less than 1.
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	// Delete a specific schedule item from the database.
+	// Delete a specific schedule item from the database. The discipline_schedule link is removed
+	// by its ON DELETE CASCADE foreign key.
 	query := `
 		DELETE FROM schedule
 		WHERE id = $1
 		`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, id)
 	return err
 }
 
-func ValidateSchedule(v *validator.Validator, schedule *Schedule) {
-	// Check if the discipline field is empty.
-	v.Check(schedule.Discipline != "", "discipline", "must be provided")
+// Conflicts scans the existing schedules and reports every cabinet that is booked more than once
+// for the same day and time period. Rows written before conflict detection existed can still
+// overlap.
+func (m ScheduleModel) Conflicts(ctx context.Context) ([]*ScheduleConflict, error) {
+	// Two bookings of the same slot only coexist peacefully when one is on odd weeks and the
+	// other on even weeks. With three or more bookings at least two of them always overlap.
+	query := `
+		SELECT min(cabinet), day_of_week, time_period, array_agg(id ORDER BY id)
+		FROM schedule
+		GROUP BY lower(trim(cabinet)), day_of_week, time_period
+		HAVING count(*) > 1
+		AND NOT (count(*) = 2 AND bool_and(week_parity <> '') AND count(DISTINCT week_parity) = 2)
+		ORDER BY min(cabinet), day_of_week, time_period
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	conflicts := []*ScheduleConflict{}
+	for rows.Next() {
+		var conflict ScheduleConflict
+		err := rows.Scan(&conflict.Cabinet, &conflict.DayOfWeek, &conflict.TimePeriod, pq.Array(&conflict.ScheduleIds))
+		if err != nil {
+			return nil, err
+		}
+
+		conflicts = append(conflicts, &conflict)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return conflicts, nil
+}
+
+// checkConflicts returns a *ScheduleConflictError if another schedule already occupies the
+// schedule's cabinet in the same day and time period in an overlapping week. Cabinet names are
+// compared case-insensitively.
+//
+// The transaction takes an advisory lock on the cabinet first, so two concurrent requests
+// booking the same cabinet are serialized and can't both pass the check. The lock is released
+// when the transaction ends.
+func checkConflicts(ctx context.Context, tx *sqlx.Tx, schedule *Schedule) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext(lower(trim($1))))`, schedule.Cabinet)
+	if err != nil {
+		return err
+	}
+
+	// Look up every booking of the slot, and leave it to conflictingIDs to decide which of them
+	// take place in the same weeks.
+	query := `
+		SELECT id, week_parity
+		FROM schedule
+		WHERE lower(trim(cabinet)) = lower(trim($1))
+		AND time_period = $2
+		AND day_of_week = $3
+		ORDER BY id
+		`
+
+	rows, err := tx.QueryContext(ctx, query, schedule.Cabinet, schedule.TimePeriod, schedule.DayOfWeek)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var booked []bookedSlot
+	for rows.Next() {
+		var slot bookedSlot
+
+		err := rows.Scan(&slot.ID, &slot.WeekParity)
+		if err != nil {
+			return err
+		}
+
+		booked = append(booked, slot)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if ids := conflictingIDs(schedule, booked); len(ids) > 0 {
+		return &ScheduleConflictError{ScheduleIds: ids}
+	}
+
+	return nil
+}
+
+// bookedSlot is an existing booking of the cabinet, day and time period of a schedule.
+type bookedSlot struct {
+	ID         int64
+	WeekParity string
+}
+
+// conflictingIDs returns the ids of the booked slots that take place in a week the schedule
+// does. The schedule itself is left out, so that updating a schedule doesn't conflict with the
+// row it replaces; new schedules don't have an id yet, so they leave out nothing.
+func conflictingIDs(schedule *Schedule, booked []bookedSlot) []int64 {
+	id, _ := strconv.ParseInt(schedule.Id, 10, 64)
+
+	var ids []int64
+	for _, slot := range booked {
+		if slot.ID != id && paritiesOverlap(slot.WeekParity, schedule.WeekParity) {
+			ids = append(ids, slot.ID)
+		}
+	}
+
+	return ids
+}
+
+// paritiesOverlap reports whether classes with the week parities a and b ever take place in the
+// same week: a class every week overlaps any other, while odd and even weeks don't overlap.
+func paritiesOverlap(a, b string) bool {
+	return a == "" || b == "" || a == b
+}
+
+// insertSchedule inserts a new schedule item and its discipline link inside tx.
+func insertSchedule(ctx context.Context, tx *sqlx.Tx, schedule *Schedule) error {
+	// Insert a new schedule item into the database.
+	query := `
+		INSERT INTO schedule (discipline, cabinet, time_period, day_of_week, week_parity)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at
+		`
+	args := []interface{}{schedule.Discipline, schedule.Cabinet, schedule.TimePeriod, schedule.DayOfWeek, schedule.WeekParity}
+
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&schedule.Id, &schedule.CreatedAt, &schedule.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	return linkDiscipline(ctx, tx, schedule)
+}
+
+// linkDiscipline points the schedule at schedule.DisciplineId in the discipline_schedule table,
+// replacing any previous link.
+func linkDiscipline(ctx context.Context, tx *sqlx.Tx, schedule *Schedule) error {
+	query := `
+		INSERT INTO discipline_schedule (discipline, schedule)
+		VALUES ($1, $2)
+		ON CONFLICT (schedule) DO UPDATE
+		SET discipline = EXCLUDED.discipline, updated_at = CURRENT_TIMESTAMP
+		`
+
+	_, err := tx.ExecContext(ctx, query, schedule.DisciplineId, schedule.Id)
+	return err
+}
+
+// ValidateSchedule checks the schedule fields. The time period must be one of the periods of the
+// provided bell schedule.
+func ValidateSchedule(v *validator.Validator, schedule *Schedule, bells BellSchedule) {
+	// Check that the schedule references a discipline.
+	v.Check(schedule.DisciplineId > 0, "disciplineId", "must be provided")
 	// Check if the discipline field is not more than 100 characters.
 	v.Check(len(schedule.Discipline) <= 100, "discipline", "must not be more than 100 bytes long")
 	// Check if the cabinet field is not more than 1000 characters.
 	v.Check(len(schedule.Cabinet) <= 1000, "cabinet", "must not be more than 1000 bytes long")
-	// Check if the nutrition value is not more than 10000.
-	v.Check(schedule.TimePeriod <= 6, "nutritionValue", "must not be more than 10000")
+	// Check that the time period is a period of the bell schedule.
+	_, ok := bells[schedule.TimePeriod]
+	v.Check(ok, "timePeriod", fmt.Sprintf("must be a period between 1 and %d", len(bells)))
+	// Check that the day of week is between Monday (1) and Sunday (7).
+	v.Check(schedule.DayOfWeek >= 1 && schedule.DayOfWeek <= 7, "dayOfWeek", "must be between 1 (Monday) and 7 (Sunday)")
+	// Check that the week parity is either empty (every week), odd or even.
+	v.Check(validator.In(schedule.WeekParity, "", ParityOdd, ParityEven), "weekParity", "must be empty, odd or even")
 }
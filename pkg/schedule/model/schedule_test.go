@@ -0,0 +1,114 @@
+package model
+
+import (
+	"database/sql"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestParitiesOverlap(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"", "", true},
+		{"", ParityOdd, true},
+		{ParityEven, "", true},
+		{ParityOdd, ParityOdd, true},
+		{ParityEven, ParityEven, true},
+		{ParityOdd, ParityEven, false},
+		{ParityEven, ParityOdd, false},
+	}
+
+	for _, tt := range tests {
+		if got := paritiesOverlap(tt.a, tt.b); got != tt.want {
+			t.Errorf("paritiesOverlap(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestConflictingIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+		booked   []bookedSlot
+		want     []int64
+	}{
+		{"free slot", Schedule{}, nil, nil},
+		{"every week against every week", Schedule{}, []bookedSlot{{ID: 1}}, []int64{1}},
+		{"odd against every week", Schedule{WeekParity: ParityOdd}, []bookedSlot{{ID: 1}}, []int64{1}},
+		{"every week against odd", Schedule{}, []bookedSlot{{ID: 1, WeekParity: ParityOdd}}, []int64{1}},
+		{"odd against odd", Schedule{WeekParity: ParityOdd}, []bookedSlot{{ID: 1, WeekParity: ParityOdd}}, []int64{1}},
+		{"odd against even", Schedule{WeekParity: ParityOdd}, []bookedSlot{{ID: 1, WeekParity: ParityEven}}, nil},
+		{
+			"only the overlapping bookings",
+			Schedule{WeekParity: ParityEven},
+			[]bookedSlot{{ID: 1, WeekParity: ParityOdd}, {ID: 2, WeekParity: ParityEven}, {ID: 3}},
+			[]int64{2, 3},
+		},
+		{"update doesn't conflict with itself", Schedule{Id: "5"}, []bookedSlot{{ID: 5}}, nil},
+		{
+			"update conflicts with others",
+			Schedule{Id: "5", WeekParity: ParityOdd},
+			[]bookedSlot{{ID: 4}, {ID: 5, WeekParity: ParityOdd}},
+			[]int64{4},
+		},
+		{"update from every week to odd", Schedule{Id: "5", WeekParity: ParityOdd}, []bookedSlot{{ID: 5}, {ID: 6, WeekParity: ParityEven}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := conflictingIDs(&tt.schedule, tt.booked)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNullDisciplineDiscipline(t *testing.T) {
+	linked := nullDiscipline{
+		Id:          sql.NullString{String: "4", Valid: true},
+		CreatedAt:   sql.NullString{String: "2025-09-01T08:00:00Z", Valid: true},
+		UpdatedAt:   sql.NullString{String: "2025-09-02T08:00:00Z", Valid: true},
+		Name:        sql.NullString{String: "Calculus II", Valid: true},
+		Description: sql.NullString{String: "Integrals.", Valid: true},
+		Credits:     sql.NullString{String: "3", Valid: true},
+		Version:     sql.NullInt64{Int64: 2, Valid: true},
+	}
+
+	tests := []struct {
+		name       string
+		discipline nullDiscipline
+		want       *Discipline
+	}{
+		{
+			name:       "linked",
+			discipline: linked,
+			want: &Discipline{Id: "4", CreatedAt: "2025-09-01T08:00:00Z", UpdatedAt: "2025-09-02T08:00:00Z",
+				Name: "Calculus II", Description: "Integrals.", Credits: "3", Version: 2},
+		},
+		{
+			name: "linked without description and credits",
+			discipline: nullDiscipline{
+				Id:      sql.NullString{String: "5", Valid: true},
+				Name:    sql.NullString{String: "Databases", Valid: true},
+				Version: sql.NullInt64{Int64: 1, Valid: true},
+			},
+			want: &Discipline{Id: "5", Name: "Databases", Version: 1},
+		},
+		{
+			name: "not linked",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.discipline.discipline(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// SchemaModel reports on the database itself rather than on any of its tables.
+type SchemaModel struct {
+	DB       *sqlx.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Timeout  time.Duration
+}
+
+// Ping checks that the database can be reached.
+func (m SchemaModel) Ping(ctx context.Context) error {
+	return m.DB.PingContext(ctx)
+}
+
+// Version returns the schema version recorded by golang-migrate in the schema_migrations table,
+// and whether the last migration failed half-way, leaving the schema dirty. A database that was
+// never migrated is at version 0.
+func (m SchemaModel) Version(ctx context.Context) (uint, bool, error) {
+	query := `
+		SELECT version, dirty
+		FROM schema_migrations
+		LIMIT 1
+		`
+
+	var (
+		version uint
+		dirty   bool
+	)
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		case errors.As(err, &pqErr) && pqErr.Code == "42P01": // undefined_table
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	return version, dirty, nil
+}
@@ -0,0 +1,180 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// LoginThrottle counts the consecutive failed logins of an email address or IP address.
+type LoginThrottle struct {
+	Key          string
+	Failures     int
+	BlockedUntil time.Time
+}
+
+// ThrottlePolicy decides how long logins are refused after consecutive failures. The first
+// failure is free, then the delay starts at Backoff and doubles with every failure, until
+// MaxFailures is reached and logins are refused for Lockout. Failures are forgotten Lockout after
+// the last one.
+type ThrottlePolicy struct {
+	MaxFailures int
+	Backoff     time.Duration
+	Lockout     time.Duration
+}
+
+// Delay returns how long logins are refused after the given number of consecutive failures.
+func (p ThrottlePolicy) Delay(failures int) time.Duration {
+	switch {
+	case failures >= p.MaxFailures:
+		return p.Lockout
+	case failures < 2:
+		return 0
+	}
+
+	delay := p.Backoff
+	for i := 2; i < failures && delay < p.Lockout; i++ {
+		delay *= 2
+	}
+
+	if delay > p.Lockout {
+		return p.Lockout
+	}
+	return delay
+}
+
+// EmailThrottleKey returns the key failed logins for an email address are counted under. Emails
+// are case-insensitive, like the users.email column.
+func EmailThrottleKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+// IPThrottleKey returns the key failed logins from an IP address are counted under.
+func IPThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+type LoginThrottleModel struct {
+	DB       *sqlx.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Timeout  time.Duration
+}
+
+// ErrLoginThrottled is returned by Reserve when logins are refused for the key.
+var ErrLoginThrottled = errors.New("login throttled")
+
+// Reserve counts a login attempt for the key as a failure, before the credentials are checked,
+// and blocks the key according to the policy. The check and the increment are a single upsert,
+// so concurrent attempts can't all slip in before the first failure is recorded. If logins are
+// refused for the key, nothing is counted and ErrLoginThrottled is returned along with the
+// throttle. A successful attempt is forgotten with Release or Delete.
+func (m LoginThrottleModel) Reserve(ctx context.Context, key string, policy ThrottlePolicy) (*LoginThrottle, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	throttle := LoginThrottle{Key: key}
+
+	// The previous failures are too old to count if the last one was more than Lockout ago. The
+	// row stays locked until the transaction ends, even when it isn't updated.
+	query := `
+		INSERT INTO login_throttles (key, failures, last_failure, blocked_until)
+		VALUES ($1, 1, $2, $2)
+		ON CONFLICT (key) DO UPDATE
+			SET failures = CASE
+					WHEN login_throttles.last_failure < $3 THEN 1
+					ELSE login_throttles.failures + 1
+				END,
+				last_failure = EXCLUDED.last_failure
+			WHERE login_throttles.blocked_until <= EXCLUDED.last_failure
+		RETURNING failures
+		`
+
+	err = tx.QueryRowxContext(ctx, query, key, now, now.Add(-policy.Lockout)).Scan(&throttle.Failures)
+	if errors.Is(err, sql.ErrNoRows) {
+		query = `
+			SELECT failures, blocked_until
+			FROM login_throttles
+			WHERE key = $1
+			`
+
+		err = tx.QueryRowxContext(ctx, query, key).Scan(&throttle.Failures, &throttle.BlockedUntil)
+		if err != nil {
+			return nil, err
+		}
+
+		return &throttle, ErrLoginThrottled
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	throttle.BlockedUntil = now.Add(policy.Delay(throttle.Failures))
+
+	query = `
+		UPDATE login_throttles
+		SET blocked_until = $2
+		WHERE key = $1
+		`
+
+	_, err = tx.ExecContext(ctx, query, key, throttle.BlockedUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop the throttles nobody failed to log in with for a while, so that attempts with random
+	// email addresses don't grow the table without bound.
+	query = `
+		DELETE FROM login_throttles
+		WHERE last_failure < $1 AND blocked_until < $2
+		`
+
+	_, err = tx.ExecContext(ctx, query, now.Add(-policy.Lockout), now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &throttle, tx.Commit()
+}
+
+// Release gives back an attempt reserved for the key that turned out to succeed. The key wasn't
+// blocked when the attempt was reserved, so any block the reservation caused is lifted too.
+func (m LoginThrottleModel) Release(ctx context.Context, key string) error {
+	query := `
+		UPDATE login_throttles
+		SET failures = failures - 1, blocked_until = LEAST(blocked_until, $2)
+		WHERE key = $1 AND failures > 0
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key, time.Now())
+	return err
+}
+
+// Delete forgets the failed logins of the key, unblocking it. It returns ErrRecordNotFound if no
+// failures were recorded for the key.
+func (m LoginThrottleModel) Delete(ctx context.Context, key string) error {
+	query := `
+		DELETE FROM login_throttles
+		WHERE key = $1
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return expectRows(m.DB.ExecContext(ctx, query, key))
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottlePolicyDelay(t *testing.T) {
+	policy := ThrottlePolicy{MaxFailures: 10, Backoff: time.Second, Lockout: 15 * time.Minute}
+
+	tests := []struct {
+		name     string
+		policy   ThrottlePolicy
+		failures int
+		want     time.Duration
+	}{
+		{"no failures", policy, 0, 0},
+		{"first failure is free", policy, 1, 0},
+		{"second failure", policy, 2, time.Second},
+		{"doubles", policy, 3, 2 * time.Second},
+		{"doubles again", policy, 5, 8 * time.Second},
+		{"last before lockout", policy, 9, 128 * time.Second},
+		{"lockout", policy, 10, 15 * time.Minute},
+		{"after lockout", policy, 11, 15 * time.Minute},
+		{
+			"capped at lockout",
+			ThrottlePolicy{MaxFailures: 100, Backoff: time.Minute, Lockout: 15 * time.Minute},
+			50,
+			15 * time.Minute,
+		},
+		{
+			"no backoff",
+			ThrottlePolicy{MaxFailures: 100, Lockout: 15 * time.Minute},
+			99,
+			0,
+		},
+		{
+			"no backoff lockout",
+			ThrottlePolicy{MaxFailures: 100, Lockout: 15 * time.Minute},
+			100,
+			15 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Delay(tt.failures); got != tt.want {
+				t.Errorf("Delay(%d) = %v, want %v", tt.failures, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThrottleKeys(t *testing.T) {
+	if got, want := EmailThrottleKey("Ada@Example.com"), "email:ada@example.com"; got != want {
+		t.Errorf("EmailThrottleKey() = %q, want %q", got, want)
+	}
+	if EmailThrottleKey("ada@example.com") == IPThrottleKey("ada@example.com") {
+		t.Error("email and IP keys collide")
+	}
+	if got, want := IPThrottleKey("192.0.2.1"), "ip:192.0.2.1"; got != want {
+		t.Errorf("IPThrottleKey() = %q, want %q", got, want)
+	}
+}
@@ -1,11 +1,13 @@
 package model
 
 import (
-	// "database/sql"
+	"database/sql"
 	"context"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
+	"errors"
 	"log"
 	"time"
 	"github.com/jmoiron/sqlx"
@@ -17,8 +19,25 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	// ScopePasswordReset tokens are short-lived and let a user who forgot their password set a
+	// new one.
+	ScopePasswordReset = "password-reset"
+	// ScopeCalendar tokens are long-lived secrets embedded in calendar subscription URLs, since
+	// calendar clients can't send an Authorization header.
+	ScopeCalendar = "calendar"
+	// ScopeRefresh tokens are long-lived and can only be exchanged for a new pair of
+	// authentication and refresh tokens. Each of them can be used once.
+	ScopeRefresh = "refresh"
+	// ScopeTwoFactor tokens are issued at login to users with two-factor authentication, and
+	// exchanged for a session along with a TOTP or recovery code.
+	ScopeTwoFactor = "two-factor"
 )
 
+// ErrTokenReused is returned when a refresh token that was already exchanged is presented again.
+// Since a legitimate client never does that, the token must have been stolen, and every token of
+// its family has been revoked.
+var ErrTokenReused = errors.New("token reused")
+
 type (
 	// Token represents a token record in our tokens table.
 	// Note, it includes plaintext and hashed version of the token.
@@ -28,6 +47,14 @@ type (
 		UserID    int64     `json:"-"`
 		Expiry    time.Time `json:"expiry"`
 		Scope     string    `json:"-"`
+		// ID, CreatedAt and UserAgent describe the session an authentication token belongs
+		// to. The ID is safe to show to the user, unlike the hash.
+		ID        int64     `json:"-"`
+		CreatedAt time.Time `json:"-"`
+		UserAgent string    `json:"-"`
+		// FamilyID links the authentication and refresh tokens descending from the same login.
+		// It's zero for tokens that don't belong to a session.
+		FamilyID int64 `json:"-"`
 	}
 
 	// TokenModel struct wraps a sql.DB connection pool and allows us to work with the Token struct
@@ -36,51 +63,439 @@ type (
 		DB       *sqlx.DB
 		InfoLog  *log.Logger
 		ErrorLog *log.Logger
+		Timeout  time.Duration
+		Cache    *Cache
+		// SignedAccess is set when the caller gives clients signed access tokens rather than
+		// the authentication tokens of NewPair and Rotate. Those are then not stored, and a
+		// session is recorded by its refresh token only.
+		SignedAccess bool
 	}
 )
 
 // New creates a new token and inserts the token record into the tokens table.
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
 	return token, err
 
 }
 
+// NewPair starts a session: it creates an authentication token and a refresh token in a new
+// family, recording the user agent of the client that logged in. With SignedAccess, only the
+// refresh token is stored.
+func (m TokenModel) NewPair(ctx context.Context, userID int64, accessTTL, refreshTTL time.Duration, userAgent string) (*Token, *Token, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var familyID int64
+	err = tx.QueryRowxContext(ctx, `SELECT nextval('tokens_family_seq')`).Scan(&familyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, err := generateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = m.insertPair(ctx, tx, access, refresh, familyID, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, tx.Commit()
+}
+
+// Rotate exchanges an unexpired refresh token for a new pair of authentication and refresh
+// tokens in the same family. The refresh token is marked as rotated, and the authentication
+// token previously issued in the family is revoked, so the session keeps a single valid pair.
+//
+// The new pair is derived from the refresh token and a random nonce stored with it, so that a
+// client retrying a refresh whose response it never received, after a timeout or with a flaky
+// mobile connection, is given the same pair again for up to grace after the rotation, as long as
+// that pair wasn't rotated in turn. Otherwise, if the refresh token was already rotated, every token of its family is
+// revoked and ErrTokenReused is returned. Unknown and expired tokens return ErrRecordNotFound.
+func (m TokenModel) Rotate(ctx context.Context, refreshPlaintext string, accessTTL, refreshTTL, grace time.Duration, userAgent string) (*Token, *Token, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the row, so that concurrent attempts to use the same token are serialised: only the
+	// first one inserts a new pair, the others are given the same pair within the grace period
+	// and are treated as reuse after it.
+	query := `
+		SELECT user_id, family_id, rotated_at, rotation_nonce
+		FROM tokens
+		WHERE scope = $1 AND hash = $2 AND expiry > $3
+		FOR UPDATE
+		`
+
+	var (
+		userID    int64
+		familyID  int64
+		rotatedAt *time.Time
+		nonce     []byte
+	)
+
+	err = tx.QueryRowxContext(ctx, query, ScopeRefresh, HashTokenPlaintext(refreshPlaintext), time.Now()).
+		Scan(&userID, &familyID, &rotatedAt, &nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if rotatedAt != nil {
+		// Tokens rotated before nonces were recorded have none, and can't be given their pair again.
+		if time.Since(*rotatedAt) <= grace && len(nonce) > 0 {
+			access, refresh, err := m.getDerivedPair(ctx, tx, nonce, refreshPlaintext, accessTTL)
+			switch {
+			case err == nil:
+				return access, refresh, tx.Commit()
+			case !errors.Is(err, ErrRecordNotFound):
+				return nil, nil, err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family_id = $1`, familyID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = tx.Commit()
+		m.Cache.invalidateUser(userID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrTokenReused
+	}
+
+	nonce, err = newRotationNonce()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query = `
+		UPDATE tokens
+		SET rotated_at = NOW(), rotation_nonce = $3
+		WHERE scope = $1 AND hash = $2
+		`
+
+	_, err = tx.ExecContext(ctx, query, ScopeRefresh, HashTokenPlaintext(refreshPlaintext), nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query = `
+		DELETE FROM tokens
+		WHERE scope = $1 AND family_id = $2
+		`
+
+	_, err = tx.ExecContext(ctx, query, ScopeAuthentication, familyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access := derivedToken(nonce, refreshPlaintext, userID, accessTTL, ScopeAuthentication)
+	refresh := derivedToken(nonce, refreshPlaintext, userID, refreshTTL, ScopeRefresh)
+
+	err = m.insertPair(ctx, tx, access, refresh, familyID, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	m.Cache.invalidateUser(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
 // Insert inserts a new token record into the tokens table.
-func (m TokenModel) Insert(token *Token) error {
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return insertToken(ctx, m.DB, token)
+}
+
+func insertToken(ctx context.Context, q sqlx.QueryerContext, token *Token) error {
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, family_id)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0))
+		RETURNING id, created_at
 		`
 
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.FamilyID}
+
+	return q.QueryRowxContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
+}
+
+// insertPair inserts an authentication token and a refresh token in the given family. With
+// SignedAccess, the authentication token is only given the family and user agent, since the
+// caller replaces it with a signed token.
+func (m TokenModel) insertPair(ctx context.Context, tx *sqlx.Tx, access, refresh *Token, familyID int64, userAgent string) error {
+	// User agents are client-controlled, don't let them grow without bound.
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+
+	for _, token := range []*Token{access, refresh} {
+		token.UserAgent = userAgent
+		token.FamilyID = familyID
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		if token == access && m.SignedAccess {
+			continue
+		}
+
+		err := insertToken(ctx, tx, token)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getDerivedPair returns the pair of tokens Rotate derived from the refresh token and the nonce
+// recorded when it was rotated, if neither of them has expired and the refresh token of the pair
+// wasn't rotated yet. Otherwise it returns ErrRecordNotFound. With SignedAccess, the
+// authentication token isn't stored: it's derived again, expiring accessTTL after the refresh
+// token was created.
+func (m TokenModel) getDerivedPair(ctx context.Context, tx *sqlx.Tx, nonce []byte, refreshPlaintext string, accessTTL time.Duration) (*Token, *Token, error) {
+	query := `
+		SELECT id, user_id, expiry, created_at, user_agent, COALESCE(family_id, 0)
+		FROM tokens
+		WHERE scope = $1 AND hash = $2 AND expiry > $3 AND rotated_at IS NULL
+		`
+
+	scopes := []string{ScopeAuthentication, ScopeRefresh}
+	if m.SignedAccess {
+		scopes = []string{ScopeRefresh}
+	}
+
+	var pair []*Token
+	for _, scope := range scopes {
+		token := derivedToken(nonce, refreshPlaintext, 0, 0, scope)
+
+		err := tx.QueryRowxContext(ctx, query, scope, token.Hash, time.Now()).
+			Scan(&token.ID, &token.UserID, &token.Expiry, &token.CreatedAt, &token.UserAgent, &token.FamilyID)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return nil, nil, ErrRecordNotFound
+			default:
+				return nil, nil, err
+			}
+		}
+
+		pair = append(pair, token)
+	}
+
+	if m.SignedAccess {
+		refresh := pair[0]
+
+		access := derivedToken(nonce, refreshPlaintext, refresh.UserID, 0, ScopeAuthentication)
+		access.Expiry = refresh.CreatedAt.Add(accessTTL)
+		access.CreatedAt = refresh.CreatedAt
+		access.UserAgent = refresh.UserAgent
+		access.FamilyID = refresh.FamilyID
+
+		return access, refresh, nil
+	}
+
+	return pair[0], pair[1], nil
+}
+
+// GetAllForUser returns the unexpired tokens of a specific user and scope, newest first. Refresh
+// tokens that were already rotated, kept to detect their reuse, are left out.
+func (m TokenModel) GetAllForUser(ctx context.Context, scope string, userID int64) ([]*Token, error) {
+	query := `
+		SELECT id, hash, user_id, expiry, scope, created_at, user_agent, COALESCE(family_id, 0)
+		FROM tokens
+		WHERE scope = $1 AND user_id = $2 AND expiry > $3 AND rotated_at IS NULL
+		ORDER BY created_at DESC, id DESC
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	rows, err := m.DB.QueryContext(ctx, query, scope, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			m.ErrorLog.Println(err)
+		}
+	}()
+
+	tokens := []*Token{}
+	for rows.Next() {
+		var token Token
+
+		err := rows.Scan(&token.ID, &token.Hash, &token.UserID, &token.Expiry, &token.Scope,
+			&token.CreatedAt, &token.UserAgent, &token.FamilyID)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Delete deletes a single token by its hash, along with the rest of its family if it belongs to
+// one, so that ending a session also revokes its refresh token. It returns ErrRecordNotFound if
+// there is no such token.
+func (m TokenModel) Delete(ctx context.Context, scope string, hash []byte) error {
+	query := `
+		DELETE FROM tokens
+		WHERE (scope = $1 AND hash = $2)
+			OR family_id IN (SELECT family_id FROM tokens WHERE scope = $1 AND hash = $2)
+		RETURNING user_id
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var userIDs []int64
+
+	err := m.DB.SelectContext(ctx, &userIDs, query, scope, hash)
+	m.Cache.invalidateToken(scope, hash)
+	if err != nil {
+		return err
+	}
+
+	switch len(userIDs) {
+	case 0:
+		return ErrRecordNotFound
+	case 1:
+	default:
+		// Other tokens of the family may be cached under hashes we don't know.
+		m.Cache.invalidateUser(userIDs[0])
+	}
+
+	return nil
+}
+
+// DeleteForUser deletes a single token of a specific user by its id, along with the rest of its
+// family if it belongs to one. It returns ErrRecordNotFound if the user has no such token.
+func (m TokenModel) DeleteForUser(ctx context.Context, scope string, userID, id int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $2
+			AND ((scope = $1 AND id = $3)
+				OR family_id IN (SELECT family_id FROM tokens WHERE scope = $1 AND user_id = $2 AND id = $3))
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := expectRows(m.DB.ExecContext(ctx, query, scope, userID, id))
+	// Only the hash identifies a cached token, so drop all of the user's cached tokens.
+	m.Cache.invalidateUser(userID)
+	return err
+}
+
+// DeleteFamily deletes every token of a specific user descending from the same login. It returns
+// ErrRecordNotFound if the user has no such tokens.
+func (m TokenModel) DeleteFamily(ctx context.Context, userID, familyID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1 AND family_id = $2
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := expectRows(m.DB.ExecContext(ctx, query, userID, familyID))
+	m.Cache.invalidateUser(userID)
 	return err
 }
 
 // DeleteAllForUser deletes all tokens for a specific user and scope.
-func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `
 		DELETE FROM tokens
 		WHERE scope = $1 AND user_id = $2
 		`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
+	m.Cache.invalidateUser(userID)
 	return err
 }
 
+// newRotationNonce returns the random key Rotate derives the pair a refresh token is exchanged
+// for with. It's stored with the rotated refresh token and never leaves the database.
+func newRotationNonce() ([]byte, error) {
+	nonce := make([]byte, 32)
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return nonce, nil
+}
+
+// derivedToken returns the token of the given scope that Rotate exchanges the refresh token
+// refreshPlaintext for. Its plaintext is an HMAC of the refresh token and the scope keyed with
+// the nonce recorded for the rotation, so it can be derived again by the server for a retry, but
+// not by someone who only stole the refresh token: without the nonce, the next tokens of the
+// session are as unpredictable as random ones.
+func derivedToken(nonce []byte, refreshPlaintext string, userID int64, ttl time.Duration, scope string) *Token {
+	mac := hmac.New(sha256.New, nonce)
+	mac.Write([]byte(refreshPlaintext))
+	// Separate the refresh token from the scope, so that no two inputs run together.
+	mac.Write([]byte{0})
+	mac.Write([]byte(scope))
+
+	// Keep 16 bytes of the MAC, so that derived tokens look like random ones.
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(mac.Sum(nil)[:16])
+
+	return &Token{
+		Plaintext: plaintext,
+		Hash:      HashTokenPlaintext(plaintext),
+		UserID:    userID,
+		Expiry:    time.Now().Add(ttl),
+		Scope:     scope,
+	}
+}
+
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	// Create a Token instance containing the user ID, expiry, and scope information.
 	// Notice that we add the provided ttl (time-to-live) duration parameter to the
@@ -124,6 +539,12 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	return token, nil
 }
 
+// HashTokenPlaintext returns the SHA-256 hash under which a token is stored in the tokens table.
+func HashTokenPlaintext(tokenPlaintext string) []byte {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	return hash[:]
+}
+
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
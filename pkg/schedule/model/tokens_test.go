@@ -0,0 +1,113 @@
+package model
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/21b030939/golang-project/pkg/schedule/validator"
+)
+
+func TestDerivedToken(t *testing.T) {
+	const refresh = "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"
+
+	nonce, err := newRotationNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	access := derivedToken(nonce, refresh, 7, time.Hour, ScopeAuthentication)
+
+	v := validator.New()
+	if ValidateTokenPlaintext(v, access.Plaintext); !v.Valid() {
+		t.Errorf("derived plaintext %q isn't a valid token: %v", access.Plaintext, v.Errors)
+	}
+	if !bytes.Equal(access.Hash, HashTokenPlaintext(access.Plaintext)) {
+		t.Error("hash doesn't match the plaintext")
+	}
+	if access.UserID != 7 || access.Scope != ScopeAuthentication || time.Until(access.Expiry) <= 59*time.Minute {
+		t.Errorf("got %+v", access)
+	}
+
+	otherNonce, err := newRotationNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		nonce     []byte
+		refresh   string
+		scope     string
+		wantEqual bool
+	}{
+		{"same nonce, refresh token and scope", nonce, refresh, ScopeAuthentication, true},
+		{"other scope", nonce, refresh, ScopeRefresh, false},
+		{"other refresh token", nonce, "Y3QMGX3PJ3WLRL2YRTQGQ6KRHV", ScopeAuthentication, false},
+		{"other nonce", otherNonce, refresh, ScopeAuthentication, false},
+		{"no nonce", nil, refresh, ScopeAuthentication, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := derivedToken(tt.nonce, tt.refresh, 7, time.Hour, tt.scope)
+			if (got.Plaintext == access.Plaintext) != tt.wantEqual {
+				t.Errorf("derived %q, first derived %q, want equal = %v", got.Plaintext, access.Plaintext, tt.wantEqual)
+			}
+		})
+	}
+
+	if access.Plaintext == refresh {
+		t.Error("derived token is the refresh token")
+	}
+}
+
+// TestDerivedTokenNeedsNonce checks that someone holding only a refresh token can't compute the
+// tokens it's exchanged for, whichever way they use the refresh token as a key or a message.
+func TestDerivedTokenNeedsNonce(t *testing.T) {
+	const refresh = "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"
+
+	encode := func(sum []byte) string {
+		return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum[:16])
+	}
+	hmacOf := func(key []byte, parts ...string) string {
+		mac := hmac.New(sha256.New, key)
+		for i, part := range parts {
+			if i > 0 {
+				mac.Write([]byte{0})
+			}
+			mac.Write([]byte(part))
+		}
+		return encode(mac.Sum(nil))
+	}
+
+	for _, scope := range []string{ScopeAuthentication, ScopeRefresh} {
+		guesses := map[string]bool{
+			hmacOf([]byte(refresh), scope):      true,
+			hmacOf(nil, refresh, scope):         true,
+			hmacOf([]byte{}, refresh, scope):    true,
+			hmacOf([]byte(scope), refresh):      true,
+			encode(HashTokenPlaintext(refresh)): true,
+		}
+
+		seen := map[string]bool{}
+		for i := 0; i < 10; i++ {
+			nonce, err := newRotationNonce()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token := derivedToken(nonce, refresh, 7, time.Hour, scope)
+			if guesses[token.Plaintext] {
+				t.Fatalf("%s token %q was computed from the refresh token alone", scope, token.Plaintext)
+			}
+			if seen[token.Plaintext] {
+				t.Fatalf("%s token %q derived twice from the same refresh token with fresh nonces", scope, token.Plaintext)
+			}
+			seen[token.Plaintext] = true
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// RecoveryCodeCount is the number of recovery codes generated when two-factor authentication is
+// enabled.
+const RecoveryCodeCount = 10
+
+// ErrTOTPEnabled is returned when enrolling a user whose two-factor authentication is already
+// enabled.
+var ErrTOTPEnabled = errors.New("totp already enabled")
+
+// recoveryCodeEncoding is Crockford's base-32 alphabet, which leaves out letters that are easily
+// mistaken for digits.
+var recoveryCodeEncoding = base32.NewEncoding("0123456789ABCDEFGHJKMNPQRSTVWXYZ").WithPadding(base32.NoPadding)
+
+// TOTP is the time-based one-time password secret of a user.
+type TOTP struct {
+	UserID    int64
+	Secret    []byte
+	Confirmed bool
+	// LastStep is the time step of the last code accepted.
+	LastStep int64
+	// FailedAttempts counts the attempts to disable two-factor authentication since the last
+	// accepted code.
+	FailedAttempts int
+}
+
+type TOTPModel struct {
+	DB       *sqlx.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Timeout  time.Duration
+}
+
+// Get returns the TOTP secret of a user. It returns ErrRecordNotFound if the user never enrolled.
+func (m TOTPModel) Get(ctx context.Context, userID int64) (*TOTP, error) {
+	query := `
+		SELECT user_id, secret, confirmed_at IS NOT NULL, last_step, failed_attempts
+		FROM totp
+		WHERE user_id = $1
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var totp TOTP
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&totp.UserID, &totp.Secret, &totp.Confirmed, &totp.LastStep, &totp.FailedAttempts)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &totp, nil
+}
+
+// Enroll stores a new, unconfirmed secret for a user, replacing any previous unconfirmed one.
+// It returns ErrTOTPEnabled if the user already confirmed a secret.
+func (m TOTPModel) Enroll(ctx context.Context, userID int64, secret []byte) error {
+	query := `
+		INSERT INTO totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+			SET secret = EXCLUDED.secret, last_step = 0
+			WHERE totp.confirmed_at IS NULL
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := expectRows(m.DB.ExecContext(ctx, query, userID, secret))
+	if errors.Is(err, ErrRecordNotFound) {
+		return ErrTOTPEnabled
+	}
+	return err
+}
+
+// Confirm enables two-factor authentication for a user, once they proved they can generate the
+// code of the time step. It replaces the user's recovery codes with new ones, which are returned
+// in plaintext and can't be retrieved again. It returns ErrRecordNotFound if the user has no
+// unconfirmed secret.
+func (m TOTPModel) Confirm(ctx context.Context, userID, step int64) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE totp
+		SET confirmed_at = NOW(), last_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL
+		`
+
+	err = expectRows(tx.ExecContext(ctx, query, userID, step))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO recovery_codes (user_id, hash)
+		SELECT $1, unnest($2::bytea[])
+		`
+
+	_, err = tx.ExecContext(ctx, query, userID, pq.ByteaArray(hashes))
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, tx.Commit()
+}
+
+// UseStep records that the code of a time step was accepted for a user, and resets their failed
+// attempts. It returns ErrRecordNotFound if a code of that step, or of a later one, was already
+// accepted, so that a code can't be replayed.
+func (m TOTPModel) UseStep(ctx context.Context, userID, step int64) error {
+	query := `
+		UPDATE totp
+		SET last_step = $2, failed_attempts = 0
+		WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_step < $2
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return expectRows(m.DB.ExecContext(ctx, query, userID, step))
+}
+
+// UseRecoveryCode consumes a recovery code of a user, and resets their failed attempts. It
+// returns ErrRecordNotFound if the user has no such code, including when it was already used.
+func (m TOTPModel) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	query := `
+		WITH used AS (
+			DELETE FROM recovery_codes
+			WHERE user_id = $1 AND hash = $2
+			RETURNING user_id
+		)
+		UPDATE totp
+		SET failed_attempts = 0
+		WHERE user_id IN (SELECT user_id FROM used)
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return expectRows(m.DB.ExecContext(ctx, query, userID, hashRecoveryCode(code)))
+}
+
+// CountDisableAttempt records an attempt to disable two-factor authentication, and returns the
+// number of attempts since the last accepted code, including this one. It's called before the
+// code is checked, so that concurrent attempts can't get past the limit; an accepted code resets
+// the count. It returns ErrRecordNotFound if the user never enrolled.
+func (m TOTPModel) CountDisableAttempt(ctx context.Context, userID int64) (int, error) {
+	query := `
+		UPDATE totp
+		SET failed_attempts = failed_attempts + 1
+		WHERE user_id = $1
+		RETURNING failed_attempts
+		`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var failures int
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&failures)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return failures, nil
+}
+
+// Delete disables two-factor authentication for a user, deleting their secret and recovery
+// codes. It returns ErrRecordNotFound if the user never enrolled.
+func (m TOTPModel) Delete(ctx context.Context, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	err = expectRows(tx.ExecContext(ctx, `DELETE FROM totp WHERE user_id = $1`, userID))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// generateRecoveryCodes returns RecoveryCodeCount random codes in the XXXXX-XXXXX format, along
+// with their hashes.
+func generateRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([][]byte, RecoveryCodeCount)
+
+	for i := range codes {
+		randomBytes := make([]byte, 10)
+
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// 10 random bytes are 16 base-32 characters, keep 10 of them (50 bits), which is plenty
+		// for a code that can only be tried behind a password.
+		encoded := recoveryCodeEncoding.EncodeToString(randomBytes)[:10]
+		codes[i] = encoded[:5] + "-" + encoded[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code ignoring case and dashes, which users often get wrong
+// when typing it.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
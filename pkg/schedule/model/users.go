@@ -1,11 +1,12 @@
 package model
 
 import (
-	// "database/sql"
 	"context"
 	"crypto/sha256"
+	"database/sql"
 	"errors"
 	"log"
+	"sync"
 	"time"
 	"github.com/jmoiron/sqlx"
 
@@ -37,6 +38,8 @@ type UserModel struct{
 	DB       *sqlx.DB
 	InfoLog  *log.Logger
 	ErrorLog *log.Logger
+	Timeout  time.Duration
+	Cache    *Cache
 }
 
 type password struct{
@@ -70,7 +73,21 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
-func (m UserModel) Insert(user *User) error {
+// dummyPasswordHash is compared against by SimulatePasswordCheck. It's generated once, with the
+// same cost as real password hashes.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), 12)
+	return hash
+})
+
+// SimulatePasswordCheck takes as long as checking a password, without checking anything. It's
+// used when no user has the email a client tried to log in with, so that the response time
+// doesn't reveal which email addresses are registered.
+func SimulatePasswordCheck(plaintextPassword string) {
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(plaintextPassword))
+}
+
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
 		INSERT INTO users (name, email, password_hash, activated)
 		VALUES ($1, $2, $3, $4)
@@ -79,7 +96,7 @@ func (m UserModel) Insert(user *User) error {
 
 	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	// If the table already contains a record with this email address, then when we try to
@@ -100,7 +117,42 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+// Get retrieves a user by id. It returns ErrRecordNotFound if no such user exists.
+func (m UserModel) Get(ctx context.Context, id int64) (*User, error) {
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE id = $1
+		`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
 		SELECT id, created_at, name, email, password_hash, activated, version
 		FROM users
@@ -109,7 +161,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
@@ -123,12 +175,12 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	)
 
 	if err != nil {
-		// switch {
-		// case errors.Is(err, sql.ErrNoRows):
-		// 	return nil, ErrRecordNotFound
-		// default:
-		// 	return nil, err
-		// }
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
 
 	return &user, nil
@@ -137,7 +189,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 // Update updates the details for a specific user in the users table. Note, we check against the
 // version field to help prevent any race conditions during the request cycle. Also, we check
 // for a violation of the "user_email_key" constraint.
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
 		UPDATE users
 		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
@@ -154,34 +206,53 @@ func (m UserModel) Update(user *User) error {
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
-		// switch {
-		// case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-		// 	return ErrDuplicateEmail
-		// case errors.Is(err, sql.ErrNoRows):
-		// 	return ErrEditConflict
-		// default:
-		// 	return err
-		// }
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
 	}
 
+	// The cached users of the user's tokens are now out of date.
+	m.Cache.invalidateUser(user.ID)
+
 	return nil
 }
 
 // GetForToken retrieves a user record from the users table for an associated token and token scope.
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+// Lookups are cached until the token expires or the cache TTL passes, whichever comes first.
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	// Calculate the SHA-256 hash for the plaintext token provided by the client.
 	// Note, that this will return a byte *array* with length 32, not a slice.
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
+	// The cache holds User values rather than pointers, so every caller gets its own copy and
+	// can't modify the cached user.
+	user, err := m.Cache.tokenCache().GetOrLoad(tokenKey(tokenScope, tokenHash[:]), func() (User, time.Time, error) {
+		return m.getForToken(ctx, tokenScope, tokenHash[:])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// getForToken looks up the user of a token in the database, returning the token expiry along
+// with the user.
+func (m UserModel) getForToken(ctx context.Context, tokenScope string, tokenHash []byte) (User, time.Time, error) {
 	query := `
 		SELECT 
 			users.id, users.created_at, users.name, users.email, 
-			users.password_hash, users.activated, users.version
+			users.password_hash, users.activated, users.version, tokens.expiry
 		FROM       users
         INNER JOIN tokens
 			ON users.id = tokens.user_id
@@ -196,11 +267,12 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	// Create a slice containing the query args. Note, that we use the [:] operator to get a slice
 	// containing the token hash, since the pq driver does not support passing in an array.
 	// Also, we pass the current time as the value to check against the token expiry.
-	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
+	args := []interface{}{tokenHash, tokenScope, time.Now()}
 
 	var user User
+	var expiry time.Time
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	// Execute the query, scanning the return values into a User struct. If no matching record
@@ -213,18 +285,19 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&expiry,
 	)
 	if err != nil {
-		// switch {
-		// case errors.Is(err, sql.ErrNoRows):
-		// 	return nil, ErrRecordNotFound
-		// default:
-		// 	return nil, err
-		// }
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return User{}, time.Time{}, ErrRecordNotFound
+		default:
+			return User{}, time.Time{}, err
+		}
 	}
 
 	// Return the matching user.
-	return &user, nil
+	return user, expiry, nil
 }
 
 // ValidateEmail checks that the Email field is not an empty string and that it matches the regex
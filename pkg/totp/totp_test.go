@@ -0,0 +1,131 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of the test vectors of RFC 6238 appendix B.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// The test vectors of RFC 6238 appendix B give 8-digit codes; 6-digit codes are their last
+	// 6 digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		if got := Code(rfcSecret, Step(time.Unix(tt.unix, 0))); got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestStep(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want int64
+	}{
+		{0, 0},
+		{29, 0},
+		{30, 1},
+		{59, 1},
+		{1111111109, 37037036},
+	}
+
+	for _, tt := range tests {
+		if got := Step(time.Unix(tt.unix, 0)); got != tt.want {
+			t.Errorf("Step(%d) = %d, want %d", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name     string
+		code     string
+		skew     int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", Code(rfcSecret, current), 1, current, true},
+		{"previous step", Code(rfcSecret, current-1), 1, current - 1, true},
+		{"next step", Code(rfcSecret, current+1), 1, current + 1, true},
+		{"outside the skew", Code(rfcSecret, current-2), 1, 0, false},
+		{"previous step without skew", Code(rfcSecret, current-1), 0, 0, false},
+		{"wrong code", "000000", 1, 0, false},
+		{"too short", Code(rfcSecret, current)[:5], 1, 0, false},
+		{"too long", Code(rfcSecret, current) + "0", 1, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now, tt.skew)
+			if step != tt.wantStep || ok != tt.wantOK {
+				t.Errorf("Validate(%q) = %d, %v, want %d, %v", tt.code, step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a) != SecretSize || len(b) != SecretSize {
+		t.Errorf("got secrets of %d and %d bytes, want %d", len(a), len(b), SecretSize)
+	}
+	if string(a) == string(b) {
+		t.Error("generated the same secret twice")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Schedule", "ada@example.com", rfcSecret)
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Schedule:ada@example.com" {
+		t.Errorf("got %s", uri)
+	}
+
+	want := url.Values{
+		"secret":    {"GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"},
+		"issuer":    {"Schedule"},
+		"algorithm": {"SHA1"},
+		"digits":    {"6"},
+		"period":    {"30"},
+	}
+	for key, values := range want {
+		if got := u.Query().Get(key); got != values[0] {
+			t.Errorf("%s = %q, want %q", key, got, values[0])
+		}
+	}
+
+	if strings.Contains(EncodeSecret(rfcSecret), "=") {
+		t.Error("encoded secret is padded")
+	}
+}